@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+	"github.com/ONSdigital/go-launch-a-survey/clients"
+	"github.com/ONSdigital/go-launch-a-survey/settings"
+	"github.com/gorilla/mux"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// The ACCESS_CONTROL modes
+const (
+	accessNone  = "none"
+	accessBasic = "basic"
+	accessOIDC  = "oidc"
+	accessProxy = "proxy"
+)
+
+// authPath prefixes the pages users log in and out with, which are open to everyone
+const authPath = "/auth"
+
+// sessionCookie holds the user logged in with OIDC
+const sessionCookie = "launcher_session"
+
+// accessError is returned when a request isn't from a user allowed to use the launcher
+type accessError struct {
+	StatusCode int
+	Desc       string
+}
+
+func (e *accessError) Error() string {
+	return e.Desc
+}
+
+// errNotAuthenticated is returned when a request doesn't say who it is from
+var errNotAuthenticated = &accessError{StatusCode: http.StatusUnauthorized, Desc: "Authentication required"}
+
+// apiUser is the user of requests authenticated with API_KEY
+const apiUser = "api"
+
+type principalContextKey struct{}
+
+type apiKeyContextKey struct{}
+
+// requestPrincipal returns the user who made the request and their groups, which are empty if access
+// control is off
+func requestPrincipal(r *http.Request) authentication.Principal {
+	principal, _ := r.Context().Value(principalContextKey{}).(authentication.Principal)
+	return principal
+}
+
+// requestUser returns the user who made the request, or "" if access control is off
+func requestUser(r *http.Request) string {
+	return requestPrincipal(r).User
+}
+
+// isAPIRequest checks whether the request was authenticated with API_KEY rather than by a browser
+func isAPIRequest(r *http.Request) bool {
+	viaAPIKey, _ := r.Context().Value(apiKeyContextKey{}).(bool)
+	return viaAPIKey
+}
+
+// byUser says who made the request for the logs, or nothing if access control is off
+func byUser(r *http.Request) string {
+	if user := requestUser(r); user != "" {
+		return " by " + user
+	}
+	return ""
+}
+
+// accessControl checks each request is from a user allowed to use the launcher, as the launcher will
+// mint valid runner tokens for anyone who can reach it
+type accessControl struct {
+	mode string
+
+	// allowedUsers are those allowed to use the launcher, or nil if any authenticated user is
+	allowedUsers map[string]bool
+
+	basicUsername string
+	basicPassword string
+
+	proxyHeader       string
+	proxyGroupsHeader string
+
+	// apiKey is given by API clients as a bearer token, as they can't log in like a browser
+	apiKey string
+
+	oidc     *oidcProvider
+	sessions *cookieSigner
+	logins   *cookieSigner
+}
+
+// newAccessControl sets up the access control chosen by ACCESS_CONTROL, or returns nil if it is off
+func newAccessControl(config *settings.Config) (*accessControl, error) {
+	mode := config.Get("ACCESS_CONTROL")
+	if mode == accessNone || mode == "" {
+		if config.Get("API_KEY") != "" {
+			log.Println("WARN: API_KEY is ignored as ACCESS_CONTROL is none")
+		}
+		return nil, nil
+	}
+
+	a := &accessControl{
+		mode:              mode,
+		proxyHeader:       config.Get("AUTH_PROXY_USER_HEADER"),
+		proxyGroupsHeader: config.Get("AUTH_PROXY_GROUPS_HEADER"),
+		apiKey:            config.Get("API_KEY"),
+	}
+	for _, user := range splitList(config.Get("ACCESS_ALLOWED_USERS")) {
+		if a.allowedUsers == nil {
+			a.allowedUsers = make(map[string]bool)
+		}
+		a.allowedUsers[strings.ToLower(user)] = true
+	}
+
+	switch mode {
+	case accessBasic:
+		a.basicUsername = config.Get("BASIC_AUTH_USERNAME")
+		a.basicPassword = config.Get("BASIC_AUTH_PASSWORD")
+		if a.basicUsername == "" || a.basicPassword == "" {
+			return nil, errors.New("BASIC_AUTH_USERNAME and BASIC_AUTH_PASSWORD must be set when ACCESS_CONTROL is basic")
+		}
+	case accessOIDC:
+		if config.Get("OIDC_ISSUER_URL") == "" || config.Get("OIDC_CLIENT_ID") == "" {
+			return nil, errors.New("OIDC_ISSUER_URL and OIDC_CLIENT_ID must be set when ACCESS_CONTROL is oidc")
+		}
+		a.oidc = &oidcProvider{
+			issuer:       strings.TrimSuffix(config.Get("OIDC_ISSUER_URL"), "/"),
+			clientID:     config.Get("OIDC_CLIENT_ID"),
+			clientSecret: config.Get("OIDC_CLIENT_SECRET"),
+			redirectURL:  config.Get("OIDC_REDIRECT_URL"),
+			userClaim:    config.Get("OIDC_USER_CLAIM"),
+			groupsClaim:  config.Get("OIDC_GROUPS_CLAIM"),
+			httpClient:   clients.NewHTTPClient(config),
+		}
+
+		lifetime, err := config.GetDuration("ACCESS_SESSION_LIFETIME")
+		if err != nil {
+			return nil, err
+		}
+		key := []byte(config.Get("ACCESS_SESSION_SECRET"))
+		if len(key) == 0 {
+			log.Println("WARN: ACCESS_SESSION_SECRET isn't set, so logins end when the launcher restarts and aren't shared between instances")
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+		}
+		a.sessions = newCookieSigner(key, "session", lifetime)
+		a.logins = newCookieSigner(key, "login", loginLifetime)
+	case accessProxy:
+		if a.proxyHeader == "" {
+			return nil, errors.New("AUTH_PROXY_USER_HEADER must be set when ACCESS_CONTROL is proxy")
+		}
+	}
+
+	return a, nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// isOpen checks whether the request is for a page anyone can use: the status page and static assets, the
+// login pages, the mock runner, which like runner checks its tokens itself, and the submission sink, which
+// runner POSTs to itself
+func isOpen(r *http.Request) bool {
+	return r.URL.Path == "/status" ||
+		strings.HasPrefix(r.URL.Path, "/static/") ||
+		strings.HasPrefix(r.URL.Path, authPath+"/") ||
+		strings.HasPrefix(r.URL.Path, mockRunnerPath+"/") ||
+		(r.Method == "POST" && r.URL.Path == submissionsPath)
+}
+
+// wrap only passes on requests from allowed users, with the user in the request's context
+func (a *accessControl) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isOpen(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.apiKey != "" && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(key), []byte(a.apiKey)) != 1 {
+				deny(w, r, &accessError{StatusCode: http.StatusUnauthorized, Desc: "Invalid API key"})
+				return
+			}
+			ctx := context.WithValue(r.Context(), principalContextKey{}, authentication.Principal{User: apiUser})
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, apiKeyContextKey{}, true)))
+			return
+		}
+
+		principal, err := a.authenticate(r)
+		if err == nil {
+			err = a.allowed(principal.User)
+		}
+		if err != nil {
+			// Browsers are sent to log in, while API clients are told they need to
+			if err == errNotAuthenticated && a.oidc != nil && r.Method == "GET" && !wantsJSON(r) {
+				a.login(w, r)
+				return
+			}
+			if err == errNotAuthenticated && a.mode == accessBasic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="go-launch-a-survey", charset="UTF-8"`)
+			}
+			deny(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal)))
+	})
+}
+
+// authenticate returns who the request is from
+func (a *accessControl) authenticate(r *http.Request) (authentication.Principal, error) {
+	switch a.mode {
+	case accessBasic:
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			return authentication.Principal{}, errNotAuthenticated
+		}
+		// Both are compared, so the time taken doesn't say which was wrong
+		usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(a.basicUsername))
+		passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(a.basicPassword))
+		if usernameMatches&passwordMatches != 1 {
+			return authentication.Principal{}, &accessError{StatusCode: http.StatusUnauthorized, Desc: "Invalid username or password"}
+		}
+		return authentication.Principal{User: username}, nil
+	case accessProxy:
+		if user := strings.TrimSpace(r.Header.Get(a.proxyHeader)); user != "" {
+			return authentication.Principal{User: user, Groups: splitList(r.Header.Get(a.proxyGroupsHeader))}, nil
+		}
+		return authentication.Principal{}, &accessError{StatusCode: http.StatusUnauthorized, Desc: fmt.Sprintf("Authentication required; %s wasn't set by the auth proxy", a.proxyHeader)}
+	case accessOIDC:
+		cookie, err := r.Cookie(sessionCookie)
+		if err != nil {
+			return authentication.Principal{}, errNotAuthenticated
+		}
+		value, err := a.sessions.decode(cookie.Value)
+		if err != nil {
+			return authentication.Principal{}, errNotAuthenticated
+		}
+		var session oidcSession
+		if err := json.Unmarshal([]byte(value), &session); err != nil || session.User == "" {
+			return authentication.Principal{}, errNotAuthenticated
+		}
+		return authentication.Principal{User: session.User, Groups: session.Groups}, nil
+	}
+	return authentication.Principal{}, &accessError{StatusCode: http.StatusInternalServerError, Desc: "Unknown ACCESS_CONTROL " + a.mode}
+}
+
+// allowed checks the user is one of ACCESS_ALLOWED_USERS, if it is set
+func (a *accessControl) allowed(user string) error {
+	if a.allowedUsers != nil && !a.allowedUsers[strings.ToLower(user)] {
+		return &accessError{StatusCode: http.StatusForbidden, Desc: fmt.Sprintf("%s isn't allowed to use the launcher", user)}
+	}
+	return nil
+}
+
+// deny responds with the reason the request isn't allowed
+func deny(w http.ResponseWriter, r *http.Request, err error) {
+	statusCode := errorStatusCode(err)
+	log.Printf("WARN: Denied %s %s: %s", r.Method, r.URL.Path, err)
+
+	if wantsJSON(r) {
+		writeJSON(w, statusCode, map[string]string{"error": err.Error()})
+		return
+	}
+	http.Error(w, err.Error(), statusCode)
+}
+
+// register adds the login pages to the router
+func (a *accessControl) register(r *mux.Router) {
+	if a.oidc != nil {
+		r.HandleFunc(authPath+"/callback", a.callbackHandler).Methods("GET")
+		r.HandleFunc(authPath+"/logout", a.logoutHandler).Methods("GET")
+	}
+}
+
+// setCookie sets one of the launcher's own cookies, only sending it over HTTPS when the launcher is
+// reached that way
+func setCookie(w http.ResponseWriter, r *http.Request, name string, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// cookieSigner signs cookie values, so the launcher can trust them when they come back without keeping
+// any state, which also lets instances behind a load balancer share logins
+type cookieSigner struct {
+	key      []byte
+	lifetime time.Duration
+}
+
+// newCookieSigner creates a cookieSigner for one purpose, with a key derived from the secret, so a cookie
+// signed for one purpose, such as a login, is never trusted as another, such as a session
+func newCookieSigner(secret []byte, purpose string, lifetime time.Duration) *cookieSigner {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return &cookieSigner{key: mac.Sum(nil), lifetime: lifetime}
+}
+
+// encode signs the value, which is trusted by decode until the lifetime has passed
+func (s *cookieSigner) encode(value string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value)) + "." + strconv.FormatInt(time.Now().Add(s.lifetime).Unix(), 10)
+	return payload + "." + s.sign(payload)
+}
+
+// decode returns the value of a cookie made by encode, if it is signed by this key and hasn't expired
+func (s *cookieSigner) decode(cookie string) (string, error) {
+	i := strings.LastIndex(cookie, ".")
+	if i < 0 || !hmac.Equal([]byte(cookie[i+1:]), []byte(s.sign(cookie[:i]))) {
+		return "", errors.New("cookie isn't signed by the launcher")
+	}
+
+	parts := strings.SplitN(cookie[:i], ".", 2)
+	if len(parts) != 2 {
+		return "", errors.New("malformed cookie")
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return "", errors.New("cookie has expired")
+	}
+	value, err := base64.RawURLEncoding.DecodeString(parts[0])
+	return string(value), err
+}
+
+func (s *cookieSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
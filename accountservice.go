@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// relaunchField is the mock account service query field holding the launcher path which launches the survey again
+const relaunchField = "relaunch"
+
+// routePath makes a configured path absolute
+func routePath(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
+
+// isLocalPath checks the path is on the launcher, so links built from it can't lead elsewhere
+func isLocalPath(path string) bool {
+	return strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") && !strings.HasPrefix(path, "/\\")
+}
+
+// accountServicePaths returns the paths of the mock account service's survey list and signed out pages
+func (l *launcher) accountServicePaths() (string, string) {
+	return routePath(l.config.Get("ACCOUNT_SERVICE_PATH")), routePath(l.config.Get("ACCOUNT_SERVICE_LOG_OUT_PATH"))
+}
+
+// accountServiceURLs returns the account service and log out URLs for a launch, which are the launcher's
+// mock account service pages. The pages link to the relaunch path, if one is given.
+func (l *launcher) accountServiceURLs(r *http.Request, relaunch string) (string, string) {
+	base := getAccountServiceURL(r)
+	surveysPath, logOutPath := l.accountServicePaths()
+
+	query := ""
+	if relaunch != "" {
+		query = "?" + url.Values{relaunchField: {relaunch}}.Encode()
+	}
+
+	return base + surveysPath + query, base + logOutPath + query
+}
+
+type accountServicePage struct {
+	SignedOut bool
+
+	// URL is where runner sent the respondent, and Referer the runner page they came from
+	URL     string
+	Referer string
+
+	Relaunch string
+}
+
+// accountServiceHandler shows where runner sent the respondent, in place of the account service
+func (l *launcher) accountServiceHandler(signedOut bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := accountServicePage{
+			SignedOut: signedOut,
+			URL:       r.URL.String(),
+			Referer:   r.Referer(),
+		}
+		if relaunch := r.URL.Query().Get(relaunchField); isLocalPath(relaunch) {
+			p.Relaunch = relaunch
+		}
+
+		serveTemplate("account-service.html", p, w, r)
+	}
+}
+
+func (l *launcher) registerAccountService(r *mux.Router) {
+	surveysPath, logOutPath := l.accountServicePaths()
+
+	// The log out path is registered first as it may be below the survey list, which also serves any path below it
+	r.Handle(logOutPath, l.accountServiceHandler(true)).Methods("GET", "POST")
+	r.PathPrefix(surveysPath).Handler(l.accountServiceHandler(false)).Methods("GET", "POST")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAccountServicePagesRelaunchTheQuickLaunch(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.get("/quick-launch?ru_name=ACME&url=" + url.QueryEscape(h.runner.URL+"/schemas/1/0205"))
+	resp.Body.Close()
+	claims := h.claims(h.tokenFromRedirect(resp, "/session"))
+
+	for _, name := range []string{"account_service_url", "account_service_log_out_url"} {
+		accountServiceURL, _ := claims[name].(string)
+		resp, err := h.client.Get(accountServiceURL)
+		if err != nil {
+			t.Fatalf("Error %s recieved, expected nil", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != 200 || !strings.Contains(string(body), "Launch the survey again") {
+			t.Fatalf("Expected %s to show the relaunch link but recieved %d: %s", name, resp.StatusCode, body)
+		}
+	}
+
+	// Relaunching uses the same seed, so generates the same values
+	relaunch, _ := url.Parse(claims["account_service_url"].(string))
+	resp = h.get(relaunch.Query().Get(relaunchField))
+	resp.Body.Close()
+	relaunched := h.claims(h.tokenFromRedirect(resp, "/session"))
+
+	if relaunched["case_id"] != claims["case_id"] || relaunched["ru_name"] != "ACME" {
+		t.Errorf("Expected the relaunch to be for the same case but recieved %v", relaunched)
+	}
+}
+
+func TestAccountServicePathsAreConfigurable(t *testing.T) {
+	h := newHarnessWithSettings(t, map[string]string{
+		"ACCOUNT_SERVICE_PATH":         "/surveys",
+		"ACCOUNT_SERVICE_LOG_OUT_PATH": "signed-out",
+	})
+	defer h.Close()
+
+	resp := h.get("/")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	for _, expected := range []string{h.launcher.URL + "/surveys?relaunch=%2F", h.launcher.URL + "/signed-out?relaunch=%2F"} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected the launch form to default to %s", expected)
+		}
+	}
+
+	for path, expected := range map[string]string{"/surveys/todo": "list of surveys", "/signed-out": "signed out"} {
+		resp := h.get(path)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || !strings.Contains(string(body), expected) {
+			t.Errorf("Expected %s to be the %s page but recieved %d", path, expected, resp.StatusCode)
+		}
+	}
+}
+
+func TestAccountServiceOnlyRelaunchesOnTheLauncher(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.get("/account-service?relaunch=" + url.QueryEscape("//example.com/quick-launch"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if strings.Contains(string(body), "Launch the survey again") {
+		t.Errorf("Expected a relaunch link off the launcher to be ignored")
+	}
+}
@@ -11,7 +11,8 @@ import (
 	"net/url"
 	"time"
 
-	"github.com/ONSdigital/go-launch-a-survey/clients"
+	"github.com/ONSdigital/go-launch-a-survey/generators"
+	"github.com/ONSdigital/go-launch-a-survey/lint"
 	"github.com/ONSdigital/go-launch-a-survey/settings"
 	"github.com/ONSdigital/go-launch-a-survey/surveys"
 	uuid "github.com/satori/go.uuid"
@@ -21,11 +22,39 @@ import (
 
 	"bytes"
 	"log"
-	"path"
 	"strconv"
 	"strings"
+	"sync"
 )
 
+// Service generates tokens for the schemas available from its surveys.Service, using the keys
+// and URLs from its config
+type Service struct {
+	config     *settings.Config
+	surveys    *surveys.Service
+	httpClient *http.Client
+
+	// defaultsConfig is kept once it has loaded, so a file which failed to load is read again
+	defaultsMutex  sync.Mutex
+	defaultsConfig *DefaultsConfig
+
+	validationCache *validationCache
+
+	// policy, if set, limits the tokens made for launcher users in the target with targetName
+	targetName string
+	policy     *Policy
+}
+
+// NewService creates a Service from the config
+func NewService(config *settings.Config, surveys *surveys.Service, httpClient *http.Client) *Service {
+	return &Service{
+		config:          config,
+		surveys:         surveys,
+		httpClient:      httpClient,
+		validationCache: newValidationCache(validationCacheSize),
+	}
+}
+
 // KeyLoadError describes an error that can occur during key loading
 type KeyLoadError struct {
 	// Op is the operation which caused the error, such as
@@ -34,6 +63,9 @@ type KeyLoadError struct {
 
 	// Err is a description of the error that occurred during the operation.
 	Err string
+
+	// From is optionally the original error from which this one was caused.
+	From error
 }
 
 func (e *KeyLoadError) Error() string {
@@ -43,6 +75,73 @@ func (e *KeyLoadError) Error() string {
 	return e.Op + ": " + e.Err
 }
 
+// Unwrap returns the underlying cause of the key loading error, if any
+func (e *KeyLoadError) Unwrap() error {
+	return e.From
+}
+
+// UpstreamError describes a failure to retrieve something from another service,
+// such as a schema from runner or the survey register
+type UpstreamError struct {
+	// URL is the address that was requested.
+	URL string
+
+	// StatusCode is the HTTP status returned by the upstream service, or 0 if
+	// no response was received.
+	StatusCode int
+
+	// Desc is a description of the error that occurred.
+	Desc string
+
+	// From is optionally the original error from which this one was caused.
+	From error
+}
+
+func (e *UpstreamError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	err := e.Desc
+	if e.From != nil {
+		err += " (" + e.From.Error() + ")"
+	}
+	return err
+}
+
+// Unwrap returns the underlying cause of the upstream error, if any
+func (e *UpstreamError) Unwrap() error {
+	return e.From
+}
+
+// ValidationError describes a schema which was rejected by the schema validator
+type ValidationError struct {
+	// Desc is a description of the error that occurred.
+	Desc string
+
+	// Errors are the problems the schema validator found.
+	Errors []SchemaError
+
+	// Hash identifies the contents of the schema which was validated.
+	Hash string
+}
+
+func (e *ValidationError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	if len(e.Errors) == 0 {
+		return e.Desc
+	}
+	messages := make([]string, len(e.Errors))
+	for i, schemaError := range e.Errors {
+		messages[i] = schemaError.Message
+		if schemaError.Pointer != "" {
+			messages[i] = schemaError.Pointer + ": " + schemaError.Message
+		}
+	}
+	return e.Desc + ": " + strings.Join(messages, "; ")
+}
+
 // PublicKeyResult is a wrapper for the public key and the kid that identifies it
 type PublicKeyResult struct {
 	key *rsa.PublicKey
@@ -55,18 +154,21 @@ type PrivateKeyResult struct {
 	kid string
 }
 
-func loadEncryptionKey() (*PublicKeyResult, *KeyLoadError) {
-	encryptionKeyPath := settings.Get("JWT_ENCRYPTION_KEY_PATH")
+func (s *Service) loadEncryptionKey() (*PublicKeyResult, *KeyLoadError) {
+	encryptionKeyPath := s.config.Get("JWT_ENCRYPTION_KEY_PATH")
 
 	keyData, err := ioutil.ReadFile(encryptionKeyPath)
 	if err != nil {
-		return nil, &KeyLoadError{Op: "read", Err: "Failed to read encryption key from file: " + encryptionKeyPath}
+		return nil, &KeyLoadError{Op: "read", Err: "Failed to read encryption key from file: " + encryptionKeyPath, From: err}
 	}
 
 	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, &KeyLoadError{Op: "parse", Err: "Failed to decode encryption key PEM"}
+	}
 	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
-		return nil, &KeyLoadError{Op: "parse", Err: "Failed to parse encryption key PEM"}
+		return nil, &KeyLoadError{Op: "parse", Err: "Failed to parse encryption key PEM", From: err}
 	}
 
 	kid := fmt.Sprintf("%x", sha1.Sum(keyData))
@@ -79,22 +181,25 @@ func loadEncryptionKey() (*PublicKeyResult, *KeyLoadError) {
 	return &PublicKeyResult{publicKey, kid}, nil
 }
 
-func loadSigningKey() (*PrivateKeyResult, *KeyLoadError) {
-	signingKeyPath := settings.Get("JWT_SIGNING_KEY_PATH")
+func (s *Service) loadSigningKey() (*PrivateKeyResult, *KeyLoadError) {
+	signingKeyPath := s.config.Get("JWT_SIGNING_KEY_PATH")
 	keyData, err := ioutil.ReadFile(signingKeyPath)
 	if err != nil {
-		return nil, &KeyLoadError{Op: "read", Err: "Failed to read signing key from file: " + signingKeyPath}
+		return nil, &KeyLoadError{Op: "read", Err: "Failed to read signing key from file: " + signingKeyPath, From: err}
 	}
 
 	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, &KeyLoadError{Op: "parse", Err: "Failed to decode signing key PEM"}
+	}
 	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	if err != nil {
-		return nil, &KeyLoadError{Op: "parse", Err: "Failed to parse signing key from PEM"}
+		return nil, &KeyLoadError{Op: "parse", Err: "Failed to parse signing key from PEM", From: err}
 	}
 
 	PublicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
 	if err != nil {
-		return nil, &KeyLoadError{Op: "marshal", Err: "Failed to marshal public key"}
+		return nil, &KeyLoadError{Op: "marshal", Err: "Failed to marshal public key", From: err}
 	}
 
 	pubBytes := pem.EncodeToMemory(&pem.Block{
@@ -117,10 +222,57 @@ type QuestionnaireSchema struct {
 type Metadata struct {
 	Name      string `json:"name"`
 	Validator string `json:"validator"`
+	Optional  bool   `json:"optional"`
 	Default   string `json:"default"`
+
+	// Attributes holds any other properties of the schema's metadata entry
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// UnmarshalJSON reads both the original `validator` metadata format and the newer format,
+// which names the validator `type` and may mark entries as `optional`
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	var entry map[string]interface{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+
+	*m = Metadata{}
+
+	for key, value := range entry {
+		switch key {
+		case "name":
+			m.Name, _ = value.(string)
+		case "validator":
+			m.Validator, _ = value.(string)
+		case "type":
+			if m.Validator == "" {
+				m.Validator, _ = value.(string)
+			}
+		case "optional":
+			m.Optional, _ = value.(bool)
+		case "default":
+			m.Default, _ = value.(string)
+		default:
+			if m.Attributes == nil {
+				m.Attributes = make(map[string]interface{})
+			}
+			m.Attributes[key] = value
+		}
+	}
+
+	return nil
 }
 
 func generateClaims(claimValues map[string][]string) (claims map[string]interface{}) {
+	claims = newClaims(claimValues)
+
+	log.Printf("Claims: %s", claims)
+
+	return claims
+}
+
+func newClaims(claimValues map[string][]string) (claims map[string]interface{}) {
 
 	var roles []string
 	if rolesValues, ok := claimValues["roles"]; ok {
@@ -141,15 +293,27 @@ func generateClaims(claimValues map[string][]string) (claims map[string]interfac
 		claims[key] = value[0]
 	}
 
-	log.Printf("Claims: %s", claims)
-
 	return claims
 }
 
+// ClaimString returns the claim if it is a string, or "" if it is missing or isn't
+func ClaimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// DefaultTokenExpiry is how long tokens are valid for unless another expiry is given
+const DefaultTokenExpiry = 10 * time.Minute
+
 // GenerateJwtClaims creates a jwtClaim needed to generate a token
 func GenerateJwtClaims() (jwtClaims map[string]interface{}) {
+	return GenerateJwtClaimsExpiringIn(DefaultTokenExpiry) // TODO: Support custom exp: r.PostForm.Get("exp")
+}
+
+// GenerateJwtClaimsExpiringIn creates the jwtClaims for a token which expires after the given duration
+func GenerateJwtClaimsExpiringIn(expiry time.Duration) (jwtClaims map[string]interface{}) {
 	issued := time.Now()
-	expires := issued.Add(time.Minute * 10) // TODO: Support custom exp: r.PostForm.Get("exp")
+	expires := issued.Add(expiry)
 
 	jwtClaims = make(map[string]interface{})
 
@@ -163,30 +327,44 @@ func GenerateJwtClaims() (jwtClaims map[string]interface{}) {
 	return jwtClaims
 }
 
-func launcherSchemaFromURL(url string) (launcherSchema surveys.LauncherSchema, error string) {
-	resp, err := clients.GetHTTPClient().Get(url)
+func (s *Service) fetchSchema(url string) ([]byte, error) {
+	resp, err := s.httpClient.Get(url)
+	if err != nil {
+		return nil, &UpstreamError{URL: url, Desc: fmt.Sprintf("Failed to contact %s", url), From: err}
+	}
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
 	if err != nil {
-		panic(err)
+		return nil, &UpstreamError{URL: url, StatusCode: resp.StatusCode, Desc: fmt.Sprintf("Failed to read Schema from %s", url), From: err}
 	}
 
 	if resp.StatusCode != 200 {
-		return launcherSchema, fmt.Sprintf("Failed to load Schema from %s", url)
+		return nil, &UpstreamError{URL: url, StatusCode: resp.StatusCode, Desc: fmt.Sprintf("Failed to load Schema from %s", url)}
 	}
 
-	responseBody, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+	return responseBody, nil
+}
+
+func (s *Service) launcherSchemaFromURL(url string) (surveys.LauncherSchema, error) {
+	var launcherSchema surveys.LauncherSchema
+
+	responseBody, err := s.fetchSchema(url)
 	if err != nil {
-		panic(err)
+		return launcherSchema, err
+	}
+
+	for _, warning := range lint.Schema(responseBody) {
+		log.Printf("WARN: %s%s: %s", url, warning.Pointer, warning.Message)
 	}
 
-	validationError := validateSchema(responseBody)
-	if validationError != "" {
-		return launcherSchema, validationError
+	if err := s.validateSchema(responseBody); err != nil {
+		return launcherSchema, err
 	}
 
 	var schema QuestionnaireSchema
 	if err := json.Unmarshal(responseBody, &schema); err != nil {
-		panic(err)
+		return launcherSchema, &UpstreamError{URL: url, StatusCode: 200, Desc: fmt.Sprintf("Failed to unmarshal Schema from %s", url), From: err}
 	}
 
 	cacheBust := ""
@@ -200,35 +378,7 @@ func launcherSchemaFromURL(url string) (launcherSchema surveys.LauncherSchema, e
 		URL:      url + cacheBust,
 	}
 
-	return launcherSchema, ""
-}
-
-func validateSchema(payload []byte) (error string) {
-	if settings.Get("SCHEMA_VALIDATOR_URL") == "" {
-		return ""
-	}
-
-	validateURL, _ := url.Parse(settings.Get("SCHEMA_VALIDATOR_URL"))
-	validateURL.Path = path.Join(validateURL.Path, "validate")
-
-	log.Println("Validating schema: ", validateURL.String())
-
-	resp, err := http.Post(validateURL.String(), "application/json", bytes.NewBuffer(payload))
-	if err != nil {
-		return err.Error()
-	}
-
-	responseBody, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		return err.Error()
-	}
-
-	if resp.StatusCode != 200 {
-		return string(responseBody)
-	}
-
-	return ""
+	return launcherSchema, nil
 }
 
 func getSchemaClaims(LauncherSchema surveys.LauncherSchema) map[string]interface{} {
@@ -243,7 +393,7 @@ func getSchemaClaims(LauncherSchema surveys.LauncherSchema) map[string]interface
 
 // TokenError describes an error that can occur during JWT generation
 type TokenError struct {
-	// Err is a description of the error that occurred.
+	// Desc is a description of the error that occurred.
 	Desc string
 
 	// From is optionally the original error from which this one was caused.
@@ -261,25 +411,47 @@ func (e *TokenError) Error() string {
 	return err
 }
 
-// generateTokenFromClaims creates a token though encryption using the private and public keys
-func generateTokenFromClaims(cl map[string]interface{}) (string, *TokenError) {
-	privateKeyResult, keyErr := loadSigningKey()
+// Unwrap returns the underlying cause of the token error, if any
+func (e *TokenError) Unwrap() error {
+	return e.From
+}
+
+// tokenKeys are the keys used to sign and encrypt tokens
+type tokenKeys struct {
+	signing    *PrivateKeyResult
+	encryption *PublicKeyResult
+}
+
+func (s *Service) loadTokenKeys() (*tokenKeys, error) {
+	privateKeyResult, keyErr := s.loadSigningKey()
 	if keyErr != nil {
-		return "", &TokenError{Desc: "Error loading signing key", From: keyErr}
+		return nil, &TokenError{Desc: "Error loading signing key", From: keyErr}
 	}
 
-	publicKeyResult, keyErr := loadEncryptionKey()
+	publicKeyResult, keyErr := s.loadEncryptionKey()
 	if keyErr != nil {
-		return "", &TokenError{Desc: "Error loading encryption key", From: keyErr}
+		return nil, &TokenError{Desc: "Error loading encryption key", From: keyErr}
 	}
 
+	return &tokenKeys{signing: privateKeyResult, encryption: publicKeyResult}, nil
+}
+
+// tokenSigner signs and encrypts tokens with keys which have already been loaded
+type tokenSigner struct {
+	signer    jose.Signer
+	encryptor jose.Encrypter
+}
+
+func (k *tokenKeys) newSigner() (*tokenSigner, error) {
+	privateKeyResult, publicKeyResult := k.signing, k.encryption
+
 	opts := jose.SignerOptions{}
 	opts.WithType("JWT")
 	opts.WithHeader("kid", privateKeyResult.kid)
 
 	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: privateKeyResult.key}, &opts)
 	if err != nil {
-		return "", &TokenError{Desc: "Error creating JWT signer", From: err}
+		return nil, &TokenError{Desc: "Error creating JWT signer", From: err}
 	}
 
 	encryptor, err := jose.NewEncrypter(
@@ -288,16 +460,38 @@ func generateTokenFromClaims(cl map[string]interface{}) (string, *TokenError) {
 		(&jose.EncrypterOptions{}).WithType("JWT").WithContentType("JWT"))
 
 	if err != nil {
-		return "", &TokenError{Desc: "Error creating JWT signer", From: err}
+		return nil, &TokenError{Desc: "Error creating JWT encrypter", From: err}
 	}
 
-	token, err := jwt.SignedAndEncrypted(signer, encryptor).Claims(cl).CompactSerialize()
+	return &tokenSigner{signer: signer, encryptor: encryptor}, nil
+}
 
+func (t *tokenSigner) token(cl map[string]interface{}) (string, error) {
+	token, err := jwt.SignedAndEncrypted(t.signer, t.encryptor).Claims(cl).CompactSerialize()
 	if err != nil {
 		return "", &TokenError{Desc: "Error signing and encrypting JWT", From: err}
 	}
+	return token, nil
+}
+
+// GenerateTokenFromClaims signs and encrypts the claims into a JWT using the configured keys
+func (s *Service) GenerateTokenFromClaims(cl map[string]interface{}) (string, error) {
+	keys, err := s.loadTokenKeys()
+	if err != nil {
+		return "", err
+	}
+
+	tokenSigner, err := keys.newSigner()
+	if err != nil {
+		return "", err
+	}
+
+	token, err := tokenSigner.token(cl)
+	if err != nil {
+		return "", err
+	}
 
-	log.Println("Created signed/encrypted JWT:", token)
+	log.Printf("Created signed/encrypted JWT with jti %v and tx_id %v", cl["jti"], cl["tx_id"])
 
 	return token, nil
 }
@@ -320,20 +514,75 @@ func getStringOrDefault(key string, values map[string][]string, defaultValue str
 }
 
 // GenerateTokenFromDefaults coverts a set of DEFAULT values into a JWT
-func GenerateTokenFromDefaults(surveyURL string, accountServiceURL string, accountServiceLogOutURL string, urlValues url.Values) (token string, error string) {
-	claims := make(map[string]interface{})
+func (s *Service) GenerateTokenFromDefaults(surveyURL string, accountServiceURL string, accountServiceLogOutURL string, urlValues url.Values) (string, error) {
+	claims, err := s.ClaimsFromURL(surveyURL, accountServiceURL, accountServiceLogOutURL, urlValues)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := s.GenerateTokenFromClaims(claims)
+	if err != nil {
+		return "", fmt.Errorf("GenerateTokenFromDefaults failed: %w", err)
+	}
+
+	return token, nil
+}
+
+// ClaimsFromURL builds the claims for quick-launching the schema at the URL, using the default metadata
+// values for any required metadata which is not given
+func (s *Service) ClaimsFromURL(surveyURL string, accountServiceURL string, accountServiceLogOutURL string, urlValues url.Values) (map[string]interface{}, error) {
 	urlValues["account_service_url"] = []string{accountServiceURL}
 	urlValues["account_service_log_out_url"] = []string{accountServiceLogOutURL}
-	claims = generateClaims(urlValues)
 
-	launcherSchema, validationError := launcherSchemaFromURL(surveyURL)
-	if validationError != "" {
-		return "", validationError
+	launcherSchema, err := s.launcherSchemaFromURL(surveyURL)
+	if err != nil {
+		return nil, err
 	}
 
-	requiredMetadata, err := GetRequiredMetadata(launcherSchema)
+	return s.ClaimsFromDefaults(launcherSchema, urlValues, DefaultTokenExpiry)
+}
+
+// ClaimsFromDefaults builds the claims for launching the schema from the given values, using
+// the default metadata values for any required metadata which is not given
+func (s *Service) ClaimsFromDefaults(launcherSchema surveys.LauncherSchema, urlValues url.Values, expiry time.Duration) (map[string]interface{}, error) {
+	seed := generators.ParseSeed(urlValues.Get(SeedField))
+
+	requiredMetadata, err := s.GetRequiredMetadata(launcherSchema, seed)
+	if err != nil {
+		return nil, fmt.Errorf("GetRequiredMetadata failed: %w", err)
+	}
+
+	defaults, err := s.GetSurveyDefaultValues(launcherSchema, seed)
 	if err != nil {
-		return "", fmt.Sprintf("GetRequiredMetadata failed err: %v", err)
+		return nil, err
+	}
+
+	claims, err := claimsFromMetadata(launcherSchema, requiredMetadata, defaults, urlValues, ClaimTemplateData{Seed: seed}, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Claims: %s", claims)
+
+	return claims, nil
+}
+
+// claimsFromMetadata builds the claims for the schema's metadata from the values, falling back to the defaults.
+// Templates in the values and defaults are evaluated with the data first.
+func claimsFromMetadata(launcherSchema surveys.LauncherSchema, requiredMetadata []Metadata, defaults map[string]string, urlValues url.Values, data ClaimTemplateData, expiry time.Duration) (map[string]interface{}, error) {
+	evaluator := newClaimTemplateEvaluator(data)
+	urlValues = evaluator.values(urlValues)
+	defaults = evaluator.defaults(defaults)
+	if err := evaluator.err(); err != nil {
+		return nil, err
+	}
+
+	claims := newClaims(urlValues)
+
+	for _, name := range []string{"ru_ref", "collection_exercise_sid", "case_id", "response_id"} {
+		if _, ok := claims[name]; !ok {
+			claims[name] = defaults[name]
+		}
 	}
 
 	for _, metadata := range requiredMetadata {
@@ -341,10 +590,24 @@ func GenerateTokenFromDefaults(surveyURL string, accountServiceURL string, accou
 			claims[metadata.Name] = getBooleanOrDefault(metadata.Name, urlValues, false)
 			continue
 		}
-		claims[metadata.Name] = getStringOrDefault(metadata.Name, urlValues, metadata.Default)
+		if metadata.Optional {
+			if urlValues.Get(metadata.Name) == "" {
+				delete(claims, metadata.Name)
+			}
+			continue
+		}
+		claims[metadata.Name] = getStringOrDefault(metadata.Name, urlValues, defaults[metadata.Name])
 	}
 
-	jwtClaims := GenerateJwtClaims()
+	if !skipValidation(urlValues) {
+		if err := ValidateMetadata(requiredMetadata, claimValues(claims)); err != nil {
+			return nil, err
+		}
+	}
+	delete(claims, LaunchAnywayField)
+	delete(claims, SeedField)
+
+	jwtClaims := GenerateJwtClaimsExpiringIn(expiry)
 	for key, v := range jwtClaims {
 		claims[key] = v
 	}
@@ -354,21 +617,49 @@ func GenerateTokenFromDefaults(surveyURL string, accountServiceURL string, accou
 		claims[key] = v
 	}
 
-	token, tokenError := generateTokenFromClaims(claims)
-	if tokenError != nil {
-		return token, fmt.Sprintf("GenerateTokenFromDefaults failed err: %v", tokenError)
+	return claims, nil
+}
+
+// FindLauncherSchema loads the schema from the URL if one is given, and otherwise finds the
+// available schema with the ID
+func (s *Service) FindLauncherSchema(schemaID string, schemaURL string) (surveys.LauncherSchema, error) {
+	if schemaURL != "" {
+		return s.launcherSchemaFromURL(schemaURL)
 	}
+	return s.surveys.FindSurvey(schemaID)
+}
 
-	return token, ""
+// GenerateTokenFromPost coverts a set of POST values into a JWT for the principal
+func (s *Service) GenerateTokenFromPost(postValues url.Values, principal Principal) (string, error) {
+	claims, err := s.ClaimsFromPost(postValues, principal)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := s.GenerateTokenFromClaims(claims)
+	if err != nil {
+		return "", fmt.Errorf("GenerateTokenFromPost failed: %w", err)
+	}
+
+	return token, nil
 }
 
-// GenerateTokenFromPost coverts a set of POST values into a JWT
-func GenerateTokenFromPost(postValues url.Values) (string, string) {
+// ClaimsFromPost builds the claims for launching, or flushing, the survey named by the launch form's POST
+// values, if the policy allows the principal to
+func (s *Service) ClaimsFromPost(postValues url.Values, principal Principal) (map[string]interface{}, error) {
 	log.Println("POST received: ", postValues)
 
+	postValues, err := evaluateClaimTemplates(postValues, ClaimTemplateData{Seed: generators.ParseSeed(postValues.Get(SeedField))})
+	if err != nil {
+		return nil, err
+	}
+
 	schema := postValues.Get("schema")
 
-	launcherSchema := surveys.FindSurveyByName(schema)
+	launcherSchema, err := s.surveys.FindSurvey(schema)
+	if err != nil {
+		return nil, err
+	}
 
 	claims := generateClaims(postValues)
 
@@ -382,35 +673,50 @@ func GenerateTokenFromPost(postValues url.Values) (string, string) {
 		claims[key] = v
 	}
 
-	requiredMetadata, err := GetRequiredMetadata(launcherSchema)
+	requiredMetadata, err := s.GetRequiredMetadata(launcherSchema, generators.ParseSeed(postValues.Get(SeedField)))
 	if err != nil {
-		return "", fmt.Sprintf("GetRequiredMetadata failed err: %v", err)
+		return nil, fmt.Errorf("GetRequiredMetadata failed: %w", err)
+	}
+
+	if !skipValidation(postValues) {
+		if err := ValidateMetadata(requiredMetadata, postValues); err != nil {
+			return nil, err
+		}
 	}
+	delete(claims, LaunchAnywayField)
+	delete(claims, SeedField)
 
 	for _, metadata := range requiredMetadata {
 		if metadata.Validator == "boolean" {
 			_, isset := claims[metadata.Name]
 			claims[metadata.Name] = isset
+			continue
+		}
+		if metadata.Optional && postValues.Get(metadata.Name) == "" {
+			delete(claims, metadata.Name)
 		}
 	}
 
-	token, tokenError := generateTokenFromClaims(claims)
-	if tokenError != nil {
-		return token, fmt.Sprintf("GenerateTokenFromPost failed err: %v", tokenError)
+	action := ActionLaunch
+	if postValues.Get("action_flush") != "" {
+		action = ActionFlush
+	}
+	if err := s.Authorize(principal, action, claims); err != nil {
+		return nil, err
 	}
 
-	return token, ""
+	return claims, nil
 }
 
 // GetRequiredMetadata Gets the required metadata from a schema
-func GetRequiredMetadata(launcherSchema surveys.LauncherSchema) ([]Metadata, error) {
+func (s *Service) GetRequiredMetadata(launcherSchema surveys.LauncherSchema, seed int64) ([]Metadata, error) {
 
 	var url string
 
 	if launcherSchema.URL != "" {
 		url = launcherSchema.URL
 	} else {
-		hostURL := settings.Get("SURVEY_RUNNER_SCHEMA_URL")
+		hostURL := s.config.Get("SURVEY_RUNNER_SCHEMA_URL")
 
 		url = fmt.Sprintf("%s/schemas/%s/%s", hostURL, launcherSchema.EqID, launcherSchema.FormType)
 	}
@@ -418,66 +724,70 @@ func GetRequiredMetadata(launcherSchema surveys.LauncherSchema) ([]Metadata, err
 	var requestBody []byte
 	var err error
 
+	// Versions of register schemas are requested with the survey, form type and version in the
+	// body as well as the query, using POST for registers which do not accept a GET with a body
+	method := "GET"
 	if launcherSchema.BodyParams.SurveyID != "" {
-		requestBody, err = json.Marshal(map[string]string{
-			"survey_id":      launcherSchema.BodyParams.SurveyID,
-			"form_type":      launcherSchema.BodyParams.FormType,
-			"survey_version": launcherSchema.BodyParams.SurveyVersion,
-		})
+		method = s.config.Get("SURVEY_REGISTER_VERSION_METHOD")
+		requestBody, err = json.Marshal(launcherSchema.BodyParams)
 		if err != nil {
 			log.Println(err)
 			return nil, fmt.Errorf("Failed to marshal JSON for request body to %s", url)
 		}
 	}
 
-	request, err := http.NewRequest("GET", url, bytes.NewBuffer(requestBody))
-	request.Header.Set("Content-type", "application/json")
+	request, err := http.NewRequest(method, url, bytes.NewBuffer(requestBody))
 	if err != nil {
 		log.Printf("Failed to build request to %s", url)
-		return nil, fmt.Errorf("Failed to build request to %s", url)
+		return nil, &UpstreamError{URL: url, Desc: fmt.Sprintf("Failed to build request to %s", url), From: err}
 	}
+	request.Header.Set("Content-type", "application/json")
 
-	log.Println("Loading metadata from url:", url)
+	log.Println("Loading metadata from url:", method, url)
 
 	if launcherSchema.BodyParams.SurveyID != "" {
 		log.Println("with body params:", string(requestBody))
 	}
 
-	resp, err := clients.GetHTTPClient().Do(request)
+	resp, err := s.httpClient.Do(request)
 	if err != nil {
 		log.Printf("Failed to recieve a response from %s", url)
-		return nil, fmt.Errorf("Failed to recieve a response from %s", url)
+		return nil, &UpstreamError{URL: url, Desc: fmt.Sprintf("Failed to recieve a response from %s", url), From: err}
 	}
 
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
 	if resp.StatusCode == 500 {
 		log.Printf("Something went wrong within the Survey Registry at  %s", url)
-		return nil, fmt.Errorf("Something went wrong within the Survey Registry at  %s", url)
+		return nil, &UpstreamError{URL: url, StatusCode: resp.StatusCode, Desc: fmt.Sprintf("Something went wrong within the Survey Registry at %s", url)}
 	}
 
 	if resp.StatusCode == 404 {
 		log.Printf("Failed to locate survey within Survey Registry at %s", url)
-		return nil, fmt.Errorf("Failed to locate survey within Survey Registry at %s", url)
+		return nil, &UpstreamError{URL: url, StatusCode: resp.StatusCode, Desc: fmt.Sprintf("Failed to locate survey within Survey Registry at %s", url)}
 	}
 
 	if resp.StatusCode != 200 {
 		log.Printf("Failed to recieve a successful response from %s", url)
-		return nil, fmt.Errorf("Failed to recieve a successful response from %s", url)
+		return nil, &UpstreamError{URL: url, StatusCode: resp.StatusCode, Desc: fmt.Sprintf("Failed to recieve a successful response from %s", url)}
 	}
 
-	responseBody, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
 	if err != nil {
 		log.Printf("Failed to read response from %s", url)
-		return nil, fmt.Errorf("Failed to read response from %s", url)
+		return nil, &UpstreamError{URL: url, StatusCode: resp.StatusCode, Desc: fmt.Sprintf("Failed to read response from %s", url), From: err}
 	}
 
 	var schema QuestionnaireSchema
 	if err := json.Unmarshal(responseBody, &schema); err != nil {
 		log.Println(err)
-		return nil, fmt.Errorf("Failed to unmarshal Schema from %s", url)
+		return nil, &UpstreamError{URL: url, StatusCode: resp.StatusCode, Desc: fmt.Sprintf("Failed to unmarshal Schema from %s", url), From: err}
 	}
 
-	defaults := GetDefaultValues()
+	defaults, err := s.GetSurveyDefaultValues(launcherSchema, seed)
+	if err != nil {
+		return nil, err
+	}
 
 	for i, value := range schema.Metadata {
 		schema.Metadata[i].Default = defaults[value.Name]
@@ -490,34 +800,11 @@ func GetRequiredMetadata(launcherSchema surveys.LauncherSchema) ([]Metadata, err
 	return schema.Metadata, nil
 }
 
-// GetDefaultValues Returns a map of default values for metadata keys
-func GetDefaultValues() map[string]string {
-
-	defaults := make(map[string]string)
-
-	u := uuid.NewV4()
+// SeedField is the form/query field holding the seed used to generate default metadata values
+const SeedField = "seed"
 
-	defaults["user_id"] = "UNKNOWN"
-	defaults["period_id"] = "201605"
-	defaults["period_str"] = "May 2017"
-	defaults["collection_exercise_sid"] = u.String()
-	defaults["ru_ref"] = "12346789012A"
-	defaults["ru_name"] = "ESSENTIAL ENTERPRISE LTD."
-	defaults["ref_p_start_date"] = "2016-05-01"
-	defaults["ref_p_end_date"] = "2016-05-31"
-	defaults["return_by"] = "2016-06-12"
-	defaults["trad_as"] = "ESSENTIAL ENTERPRISE LTD."
-	defaults["employment_date"] = "2016-06-10"
-	defaults["region_code"] = "GB-ENG"
-	defaults["language_code"] = "en"
-	defaults["case_ref"] = "1000000000000001"
-	defaults["address_line1"] = "68 Abingdon Road"
-	defaults["address_line2"] = ""
-	defaults["locality"] = ""
-	defaults["town_name"] = "Goathill"
-	defaults["postcode"] = "PE12 4GH"
-	defaults["display_address"] = "68 Abingdon Road, Goathill"
-	defaults["country"] = "E"
-
-	return defaults
+// GetDefaultValues Returns a map of default values for metadata keys, generated from the seed.
+// A seed of 0 produces a different set of values on each call.
+func GetDefaultValues(seed int64) map[string]string {
+	return generators.New(seed).Values()
 }
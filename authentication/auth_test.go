@@ -0,0 +1,138 @@
+package authentication
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/go-launch-a-survey/settings"
+	"gopkg.in/square/go-jose.v2/json"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// newTestService creates a Service with the repository's test keys, so it can sign and encrypt tokens
+func newTestService() *Service {
+	config := settings.FromValues(map[string]string{
+		"JWT_ENCRYPTION_KEY_PATH": "../jwt-test-keys/sdc-user-authentication-encryption-sr-public-key.pem",
+		"JWT_SIGNING_KEY_PATH":    "../jwt-test-keys/sdc-user-authentication-signing-launcher-private-key.pem",
+	})
+	return NewService(config, nil, http.DefaultClient)
+}
+
+func TestLauncherSchemaFromURLReturnsUpstreamErrorForMissingSchema(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	_, err := newTestService().launcherSchemaFromURL(server.URL + "/schemas/missing")
+
+	var upstreamError *UpstreamError
+	if !errors.As(err, &upstreamError) {
+		t.Fatalf("Expected an UpstreamError but recieved %v", err)
+	}
+	if upstreamError.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code %d but recieved %d", http.StatusNotFound, upstreamError.StatusCode)
+	}
+}
+
+func TestLauncherSchemaFromURLReturnsUpstreamErrorForInvalidJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not json"))
+	}))
+	defer server.Close()
+
+	_, err := newTestService().launcherSchemaFromURL(server.URL)
+
+	var upstreamError *UpstreamError
+	if !errors.As(err, &upstreamError) {
+		t.Fatalf("Expected an UpstreamError but recieved %v", err)
+	}
+	if errors.Unwrap(err) == nil {
+		t.Errorf("Expected the unmarshal error to be wrapped")
+	}
+}
+
+func TestLauncherSchemaFromURLBuildsLauncherSchema(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"eq_id": "census", "form_type": "household", "metadata": []}`))
+	}))
+	defer server.Close()
+
+	launcherSchema, err := newTestService().launcherSchemaFromURL(server.URL)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if launcherSchema.EqID != "census" || launcherSchema.FormType != "household" {
+		t.Errorf("Built launcherSchema incorrectly; recieved %v", launcherSchema)
+	}
+}
+
+func TestTokenErrorUnwrapsKeyLoadError(t *testing.T) {
+	keyErr := &KeyLoadError{Op: "read", Err: "Failed to read signing key from file: missing.pem"}
+	err := error(&TokenError{Desc: "Error loading signing key", From: keyErr})
+
+	var target *KeyLoadError
+	if !errors.As(err, &target) {
+		t.Fatalf("Expected TokenError to unwrap to KeyLoadError")
+	}
+	if target.Op != "read" {
+		t.Errorf("Expected op read but recieved %s", target.Op)
+	}
+}
+
+func TestMetadataUnmarshalsNewerSchemaFormat(t *testing.T) {
+	var schema QuestionnaireSchema
+	err := json.Unmarshal([]byte(`{
+		"eq_id": "census",
+		"form_type": "household",
+		"metadata": [
+			{"name": "ru_ref", "validator": "string"},
+			{"name": "trad_as", "type": "string", "optional": true, "description": "Trading name"}
+		]
+	}`), &schema)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+
+	if schema.Metadata[0].Optional || schema.Metadata[0].Validator != "string" {
+		t.Errorf("Expected ru_ref to be a required string but recieved %v", schema.Metadata[0])
+	}
+
+	tradAs := schema.Metadata[1]
+	if !tradAs.Optional || tradAs.Validator != "string" {
+		t.Errorf("Expected trad_as to be an optional string but recieved %v", tradAs)
+	}
+	if tradAs.Attributes["description"] != "Trading name" {
+		t.Errorf("Expected the description attribute to be kept but recieved %v", tradAs.Attributes)
+	}
+}
+
+func TestClaimsFromDefaultsUsesExpiryAndGivenClaims(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"eq_id": "census", "form_type": "household", "metadata": [{"name": "ru_name", "validator": "string"}]}`))
+	}))
+	defer server.Close()
+
+	service := newTestService()
+	launcherSchema, err := service.FindLauncherSchema("", server.URL)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+
+	claims, err := service.ClaimsFromDefaults(launcherSchema, url.Values{"ru_name": {"ACME"}}, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+
+	if claims["ru_name"] != "ACME" || claims["eq_id"] != "census" {
+		t.Errorf("Expected the given and schema claims but recieved %v", claims)
+	}
+	issued, expires := claims["iat"].(*jwt.NumericDate), claims["exp"].(*jwt.NumericDate)
+	if expires.Time().Sub(issued.Time()) != 30*time.Minute {
+		t.Errorf("Expected the token to expire after 30 minutes but recieved %v", expires.Time().Sub(issued.Time()))
+	}
+}
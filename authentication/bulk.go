@@ -0,0 +1,180 @@
+package authentication
+
+import (
+	"fmt"
+	"net/url"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/go-launch-a-survey/generators"
+	"github.com/ONSdigital/go-launch-a-survey/surveys"
+)
+
+// BulkRequest describes a batch of tokens to generate for one schema
+type BulkRequest struct {
+	LauncherSchema surveys.LauncherSchema
+	Count          int
+
+	// Claims are given to every token. Values are templates, so {{.Index}} and {{seq 1}} differ for each token.
+	Claims url.Values
+
+	// Seed fixes the generated values; each token uses Seed plus its index. A random seed is used if 0.
+	Seed int64
+
+	Expiry time.Duration
+
+	// Workers is the number of tokens generated at once, defaulting to the number of CPUs
+	Workers int
+
+	// Principal is who the tokens are for, which the policy must allow to launch with each of them
+	Principal Principal
+}
+
+// BulkToken is a generated token with the identifiers which make it distinct from the others in the batch
+type BulkToken struct {
+	Index                 int    `json:"index"`
+	Token                 string `json:"token"`
+	URL                   string `json:"url"`
+	CaseID                string `json:"case_id"`
+	ResponseID            string `json:"response_id"`
+	RURef                 string `json:"ru_ref"`
+	CollectionExerciseSID string `json:"collection_exercise_sid"`
+}
+
+type bulkResult struct {
+	token BulkToken
+	err   error
+}
+
+// GenerateBulkTokens generates the requested number of tokens concurrently, calling emit with each token
+// in index order. The schema's metadata and the keys are loaded once for the whole batch. Generation stops
+// at the first error, including any returned by emit.
+func (s *Service) GenerateBulkTokens(req BulkRequest, emit func(BulkToken) error) error {
+	seed := generators.New(req.Seed).Seed
+	workers := req.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	if err := checkClaimTemplates(req.Claims); err != nil {
+		return err
+	}
+
+	requiredMetadata, err := s.GetRequiredMetadata(req.LauncherSchema, seed)
+	if err != nil {
+		return fmt.Errorf("GetRequiredMetadata failed: %w", err)
+	}
+
+	defaultsConfig, err := s.LoadDefaultsConfig()
+	if err != nil {
+		return err
+	}
+
+	keys, err := s.loadTokenKeys()
+	if err != nil {
+		return err
+	}
+
+	// ru_refs are sequential from the first token's, so every token in the batch has a different one
+	ruRefGenerator := defaultsConfig.Generator(req.LauncherSchema, seed)
+	firstRURef, _ := strconv.ParseInt(ruRefGenerator.RURef()[:11], 10, 64)
+
+	runnerURL := s.config.Get("SURVEY_RUNNER_URL")
+	if _, err := RunnerURL(runnerURL, SessionEndpoint, ""); err != nil {
+		return err
+	}
+
+	jobs := make(chan int)
+	results := make(chan bulkResult)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		tokenSigner, err := keys.newSigner()
+		if err != nil {
+			close(jobs)
+			wg.Wait()
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				token, err := s.bulkToken(req, index, seed+int64(index), requiredMetadata, defaultsConfig, ruRefGenerator.SequentialRURef(firstRURef, int64(index)), tokenSigner)
+				token.URL, _ = RunnerURL(runnerURL, SessionEndpoint, token.Token)
+				select {
+				case results <- bulkResult{token: token, err: err}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := 0; i < req.Count; i++ {
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Tokens finish out of order, so hold each one until those before it have been emitted
+	pending := make(map[int]BulkToken)
+	next := 0
+	for result := range results {
+		if result.err != nil {
+			close(done)
+			return result.err
+		}
+		pending[result.token.Index] = result.token
+		for token, ok := pending[next]; ok; token, ok = pending[next] {
+			delete(pending, next)
+			next++
+			if err := emit(token); err != nil {
+				close(done)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) bulkToken(req BulkRequest, index int, seed int64, requiredMetadata []Metadata, defaultsConfig *DefaultsConfig, ruRef string, tokenSigner *tokenSigner) (BulkToken, error) {
+	bulkToken := BulkToken{Index: index}
+
+	defaults := defaultsConfig.Values(req.LauncherSchema, seed)
+	defaults["ru_ref"] = ruRef
+
+	claims, err := claimsFromMetadata(req.LauncherSchema, requiredMetadata, defaults, req.Claims, ClaimTemplateData{Index: index, Seed: seed}, req.Expiry)
+	if err != nil {
+		return bulkToken, fmt.Errorf("Token %d: %w", index, err)
+	}
+	if err := s.Authorize(req.Principal, ActionLaunch, claims); err != nil {
+		return bulkToken, err
+	}
+
+	bulkToken.Token, err = tokenSigner.token(claims)
+	if err != nil {
+		return bulkToken, err
+	}
+
+	bulkToken.CaseID = fmt.Sprint(claims["case_id"])
+	bulkToken.ResponseID = fmt.Sprint(claims["response_id"])
+	bulkToken.RURef = fmt.Sprint(claims["ru_ref"])
+	bulkToken.CollectionExerciseSID = fmt.Sprint(claims["collection_exercise_sid"])
+
+	return bulkToken, nil
+}
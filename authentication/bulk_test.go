@@ -0,0 +1,73 @@
+package authentication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestGenerateBulkTokensAreDistinctAndInOrder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"eq_id": "census", "form_type": "household", "metadata": [{"name": "ru_name", "validator": "string"}]}`))
+	}))
+	defer server.Close()
+
+	service := newTestService()
+	launcherSchema, err := service.FindLauncherSchema("", server.URL)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+
+	var tokens []BulkToken
+	err = service.GenerateBulkTokens(BulkRequest{
+		LauncherSchema: launcherSchema,
+		Count:          50,
+		Claims:         url.Values{"ru_name": {"Company {{.Index}}"}},
+		Seed:           42,
+		Expiry:         DefaultTokenExpiry,
+		Workers:        4,
+	}, func(token BulkToken) error {
+		tokens = append(tokens, token)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+
+	if len(tokens) != 50 {
+		t.Fatalf("Expected 50 tokens but recieved %d", len(tokens))
+	}
+	seen := make(map[string]bool)
+	for i, token := range tokens {
+		if token.Index != i {
+			t.Errorf("Expected token %d at position %d", token.Index, i)
+		}
+		for _, id := range []string{token.Token, token.CaseID, token.ResponseID, token.RURef, token.CollectionExerciseSID} {
+			if seen[id] {
+				t.Errorf("Token %d reuses %s", i, id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestGenerateBulkTokensReportsInvalidTemplates(t *testing.T) {
+	err := newTestService().GenerateBulkTokens(BulkRequest{
+		Count:  1,
+		Claims: url.Values{"ru_name": {"{{.Missing"}},
+	}, func(BulkToken) error { return nil })
+	if err == nil {
+		t.Errorf("Expected an error for the invalid template")
+	}
+}
+
+func TestClaimTemplatesUseTheIndex(t *testing.T) {
+	values, err := evaluateClaimTemplates(url.Values{"ru_name": {"Company {{.Index}}"}, "trad_as": {"Static"}}, ClaimTemplateData{Index: 7})
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if values.Get("ru_name") != "Company 7" || values.Get("trad_as") != "Static" {
+		t.Errorf("Expected the templates to be evaluated but recieved %v", values)
+	}
+}
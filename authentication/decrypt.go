@@ -0,0 +1,172 @@
+package authentication
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// DecryptedToken is a token which has been decrypted and had its signature verified, as runner does
+// when a survey is launched
+type DecryptedToken struct {
+	// EncryptionKeyID and SigningKeyID are the kids in the token's headers
+	EncryptionKeyID string
+	SigningKeyID    string
+
+	Claims   map[string]interface{}
+	IssuedAt time.Time
+	Expiry   time.Time
+}
+
+// VerificationKey returns the public half of the signing key, with the kid tokens are signed with
+func (s *Service) VerificationKey() (string, *rsa.PublicKey, error) {
+	privateKeyResult, keyErr := s.loadSigningKey()
+	if keyErr != nil {
+		return "", nil, &TokenError{Desc: "Error loading signing key", From: keyErr}
+	}
+	return privateKeyResult.kid, &privateKeyResult.key.PublicKey, nil
+}
+
+// LoadDecryptionKey reads a PKCS #1 or PKCS #8 RSA private key from a PEM file, such as runner's key
+// for decrypting tokens
+func LoadDecryptionKey(path string) (*rsa.PrivateKey, error) {
+	keyData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &KeyLoadError{Op: "read", Err: "Failed to read decryption key from file: " + path, From: err}
+	}
+
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, &KeyLoadError{Op: "parse", Err: "Failed to decode decryption key PEM"}
+	}
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, &KeyLoadError{Op: "parse", Err: "Failed to parse decryption key from PEM", From: err}
+	}
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, &KeyLoadError{Op: "cast", Err: "Failed to cast key to rsa.PrivateKey"}
+	}
+	return privateKey, nil
+}
+
+// LoadVerificationKey reads an RSA public key from a PEM file, such as runner's key for verifying its signatures
+func LoadVerificationKey(path string) (*rsa.PublicKey, error) {
+	keyData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &KeyLoadError{Op: "read", Err: "Failed to read verification key from file: " + path, From: err}
+	}
+
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, &KeyLoadError{Op: "parse", Err: "Failed to decode verification key PEM"}
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, &KeyLoadError{Op: "parse", Err: "Failed to parse verification key PEM", From: err}
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, &KeyLoadError{Op: "cast", Err: "Failed to cast key to rsa.PublicKey"}
+	}
+	return publicKey, nil
+}
+
+// IsCompactJOSE reports whether the payload is a compact serialised JWE (five parts) or JWS (three parts)
+func IsCompactJOSE(payload string) bool {
+	payload = strings.TrimSpace(payload)
+	dots := strings.Count(payload, ".")
+	return (dots == 2 || dots == 4) && !strings.ContainsAny(payload, " {")
+}
+
+// DecryptPayload reads a compact JWE or JWS, such as runner's downstream submissions. A JWE is decrypted
+// with the decryption key, and if it holds a JWS that is read too. The JWS's signature is verified if a
+// verification key is given, and verified reports whether it was.
+func DecryptPayload(payload string, decryptionKey *rsa.PrivateKey, verificationKey *rsa.PublicKey) (plaintext []byte, verified bool, err error) {
+	payload = strings.TrimSpace(payload)
+
+	if strings.Count(payload, ".") == 4 {
+		if decryptionKey == nil {
+			return nil, false, &TokenError{Desc: "Payload is encrypted but no decryption key is configured"}
+		}
+		encrypted, err := jose.ParseEncrypted(payload)
+		if err != nil {
+			return nil, false, &TokenError{Desc: "Failed to parse encrypted payload", From: err}
+		}
+		if plaintext, err = encrypted.Decrypt(decryptionKey); err != nil {
+			return nil, false, &TokenError{Desc: "Failed to decrypt payload", From: err}
+		}
+		if !IsCompactJOSE(string(plaintext)) {
+			return plaintext, false, nil
+		}
+		payload = strings.TrimSpace(string(plaintext))
+	}
+
+	signed, err := jose.ParseSigned(payload)
+	if err != nil {
+		return nil, false, &TokenError{Desc: "Failed to parse signed payload", From: err}
+	}
+	if verificationKey == nil {
+		return signed.UnsafePayloadWithoutVerification(), false, nil
+	}
+	if plaintext, err = signed.Verify(verificationKey); err != nil {
+		return nil, false, &TokenError{Desc: "Failed to verify payload signature", From: err}
+	}
+	return plaintext, true, nil
+}
+
+// DecryptToken decrypts the token with the private key, then verifies its signature with the
+// verification key matching its kid and checks it has not expired
+func DecryptToken(token string, decryptionKey *rsa.PrivateKey, verificationKeys map[string]*rsa.PublicKey) (*DecryptedToken, error) {
+	nested, err := jwt.ParseSignedAndEncrypted(token)
+	if err != nil {
+		return nil, &TokenError{Desc: "Failed to parse token", From: err}
+	}
+
+	decrypted := &DecryptedToken{}
+	if len(nested.Headers) > 0 {
+		decrypted.EncryptionKeyID = nested.Headers[0].KeyID
+	}
+
+	signed, err := nested.Decrypt(decryptionKey)
+	if err != nil {
+		return nil, &TokenError{Desc: "Failed to decrypt token", From: err}
+	}
+	if len(signed.Headers) > 0 {
+		decrypted.SigningKeyID = signed.Headers[0].KeyID
+	}
+
+	verificationKey, ok := verificationKeys[decrypted.SigningKeyID]
+	if !ok {
+		return nil, &TokenError{Desc: fmt.Sprintf("No verification key for kid %q", decrypted.SigningKeyID)}
+	}
+
+	var registered jwt.Claims
+	if err := signed.Claims(verificationKey, &decrypted.Claims, &registered); err != nil {
+		return nil, &TokenError{Desc: "Failed to verify token signature", From: err}
+	}
+
+	if registered.IssuedAt != nil {
+		decrypted.IssuedAt = registered.IssuedAt.Time()
+	}
+	if registered.Expiry != nil {
+		decrypted.Expiry = registered.Expiry.Time()
+	}
+
+	if err := registered.Validate(jwt.Expected{Time: time.Now()}); err != nil {
+		return decrypted, &TokenError{Desc: "Token is not valid", From: err}
+	}
+
+	return decrypted, nil
+}
@@ -0,0 +1,98 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestDecryptTokenVerifiesTheSignatureAndExpiry(t *testing.T) {
+	service := newTestService()
+	keys, err := service.loadTokenKeys()
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+
+	// Encrypt to a generated runner key, as the repository only has runner's public key
+	runnerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	keys.encryption = &PublicKeyResult{key: &runnerKey.PublicKey, kid: "runner"}
+	tokenSigner, err := keys.newSigner()
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+
+	kid, verificationKey, err := service.VerificationKey()
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	verificationKeys := map[string]*rsa.PublicKey{kid: verificationKey}
+
+	claims := GenerateJwtClaimsExpiringIn(time.Minute)
+	claims["ru_name"] = "ACME"
+	token, _ := tokenSigner.token(claims)
+
+	decrypted, err := DecryptToken(token, runnerKey, verificationKeys)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if decrypted.Claims["ru_name"] != "ACME" || decrypted.EncryptionKeyID != "runner" || decrypted.SigningKeyID != kid {
+		t.Errorf("Expected the token's claims and kids but recieved %+v", decrypted)
+	}
+
+	if _, err := DecryptToken(token, runnerKey, map[string]*rsa.PublicKey{}); err == nil {
+		t.Errorf("Expected an error for a token signed with an unknown key")
+	}
+
+	expired, _ := tokenSigner.token(GenerateJwtClaimsExpiringIn(-time.Hour))
+	if _, err := DecryptToken(expired, runnerKey, verificationKeys); err == nil {
+		t.Errorf("Expected an error for an expired token")
+	}
+}
+
+func TestDecryptPayloadReadsSignedAndEncryptedSubmissions(t *testing.T) {
+	runnerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	sinkKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+
+	// Sign with runner's key, then encrypt for the sink, as runner does its submissions
+	signer, _ := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: runnerKey}, nil)
+	signed, _ := signer.Sign([]byte(`{"tx_id": "abc"}`))
+	jws, _ := signed.CompactSerialize()
+	encrypter, _ := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: jose.RSA_OAEP, Key: &sinkKey.PublicKey}, nil)
+	encrypted, _ := encrypter.Encrypt([]byte(jws))
+	jwe, _ := encrypted.CompactSerialize()
+
+	if !IsCompactJOSE(jwe) || !IsCompactJOSE(jws) || IsCompactJOSE(`{"tx_id": "abc"}`) {
+		t.Errorf("Expected only the JWE and JWS to be recognised")
+	}
+
+	plaintext, verified, err := DecryptPayload(jwe, sinkKey, &runnerKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if string(plaintext) != `{"tx_id": "abc"}` || !verified {
+		t.Errorf("Expected the verified payload but recieved %s (verified %v)", plaintext, verified)
+	}
+
+	if plaintext, verified, err = DecryptPayload(jwe, sinkKey, nil); err != nil || verified || string(plaintext) != `{"tx_id": "abc"}` {
+		t.Errorf("Expected the unverified payload without a verification key but recieved %s, %s", plaintext, err)
+	}
+
+	if _, _, err := DecryptPayload(jwe, sinkKey, &sinkKey.PublicKey); err == nil {
+		t.Errorf("Expected an error when the signature doesn't verify")
+	}
+	if _, _, err := DecryptPayload(jwe, nil, nil); err == nil {
+		t.Errorf("Expected an error without a decryption key")
+	}
+}
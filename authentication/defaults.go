@@ -0,0 +1,182 @@
+package authentication
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+
+	"github.com/ONSdigital/go-launch-a-survey/generators"
+	"github.com/ONSdigital/go-launch-a-survey/surveys"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// DefaultsConfig is the contents of the defaults configuration file. Values are layered over
+// the generated defaults in order: global defaults, eq_id, eq_id and form_type, then schema ID.
+type DefaultsConfig struct {
+	Defaults map[string]string `json:"defaults"`
+	Surveys  []SurveyDefaults  `json:"surveys"`
+}
+
+// SurveyDefaults holds the default values for the surveys matching its eq_id, form_type or schema name
+type SurveyDefaults struct {
+	EqID     string `json:"eq_id"`
+	FormType string `json:"form_type"`
+	Schema   string `json:"schema"`
+
+	Values map[string]string `json:"values"`
+
+	// RURefRange restricts the generated ru_ref to a range of 11 digit references
+	RURefRange *RURefRange `json:"ru_ref_range"`
+
+	// PeriodIDFormat and PeriodStrFormat are Go time layouts for the generated period, e.g. "0601"
+	PeriodIDFormat  string `json:"period_id_format"`
+	PeriodStrFormat string `json:"period_str_format"`
+}
+
+// RURefRange is an inclusive range of ru_refs, excluding the check letter
+type RURefRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (s SurveyDefaults) precedence() int {
+	switch {
+	case s.Schema != "":
+		return 3
+	case s.FormType != "":
+		return 2
+	default:
+		return 1
+	}
+}
+
+func (s SurveyDefaults) matches(launcherSchema surveys.LauncherSchema) bool {
+	if s.Schema != "" {
+		return s.Schema == launcherSchema.ID
+	}
+	if s.EqID != launcherSchema.EqID {
+		return false
+	}
+	return s.FormType == "" || s.FormType == launcherSchema.FormType
+}
+
+func (s SurveyDefaults) apply(generator *generators.Generator) {
+	if s.PeriodIDFormat != "" {
+		generator.PeriodIDFormat = s.PeriodIDFormat
+	}
+	if s.PeriodStrFormat != "" {
+		generator.PeriodStrFormat = s.PeriodStrFormat
+	}
+	if s.RURefRange != nil {
+		generator.RURefMin, _ = strconv.ParseInt(s.RURefRange.From, 10, 64)
+		generator.RURefMax, _ = strconv.ParseInt(s.RURefRange.To, 10, 64)
+	}
+}
+
+func (c *DefaultsConfig) validate() error {
+	for i, survey := range c.Surveys {
+		if survey.EqID == "" && survey.Schema == "" {
+			return fmt.Errorf("surveys[%d] must have an eq_id or schema", i)
+		}
+		if survey.RURefRange == nil {
+			continue
+		}
+		from, fromErr := strconv.ParseInt(survey.RURefRange.From, 10, 64)
+		to, toErr := strconv.ParseInt(survey.RURefRange.To, 10, 64)
+		if len(survey.RURefRange.From) != 11 || len(survey.RURefRange.To) != 11 || fromErr != nil || toErr != nil || from > to {
+			return fmt.Errorf("surveys[%d] ru_ref_range must be two 11 digit references in ascending order", i)
+		}
+	}
+	return nil
+}
+
+// LoadDefaultsConfig reads the defaults configuration file from DEFAULTS_CONFIG_PATH, keeping it once
+// it has loaded. A file which fails to load is read again by the next call, so it can be fixed without
+// a restart. An empty configuration is returned if no path is set.
+func (s *Service) LoadDefaultsConfig() (*DefaultsConfig, error) {
+	s.defaultsMutex.Lock()
+	defer s.defaultsMutex.Unlock()
+
+	if s.defaultsConfig != nil {
+		return s.defaultsConfig, nil
+	}
+	config, err := readDefaultsConfig(s.config.Get("DEFAULTS_CONFIG_PATH"))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	s.defaultsConfig = config
+	return config, nil
+}
+
+func readDefaultsConfig(path string) (*DefaultsConfig, error) {
+	config := &DefaultsConfig{}
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read defaults config from %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("Failed to parse defaults config from %s: %w", path, err)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid defaults config in %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+func (c *DefaultsConfig) matching(launcherSchema surveys.LauncherSchema) []SurveyDefaults {
+	var matching []SurveyDefaults
+	for precedence := 1; precedence <= 3; precedence++ {
+		for _, survey := range c.Surveys {
+			if survey.precedence() == precedence && survey.matches(launcherSchema) {
+				matching = append(matching, survey)
+			}
+		}
+	}
+	return matching
+}
+
+// Generator returns a generator for the schema's values, with the configured period formats and ru_ref range
+func (c *DefaultsConfig) Generator(launcherSchema surveys.LauncherSchema, seed int64) *generators.Generator {
+	generator := generators.New(seed)
+	for _, survey := range c.matching(launcherSchema) {
+		survey.apply(generator)
+	}
+	return generator
+}
+
+// Values returns the default values for the schema, layering the configured overrides over
+// values generated from the seed
+func (c *DefaultsConfig) Values(launcherSchema surveys.LauncherSchema, seed int64) map[string]string {
+	matching := c.matching(launcherSchema)
+
+	values := c.Generator(launcherSchema, seed).Values()
+	for key, value := range c.Defaults {
+		values[key] = value
+	}
+	for _, survey := range matching {
+		for key, value := range survey.Values {
+			values[key] = value
+		}
+	}
+
+	return values
+}
+
+// GetSurveyDefaultValues returns the default metadata values for the schema, including any
+// global and per-survey overrides from the defaults configuration file
+func (s *Service) GetSurveyDefaultValues(launcherSchema surveys.LauncherSchema, seed int64) (map[string]string, error) {
+	config, err := s.LoadDefaultsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Values(launcherSchema, seed), nil
+}
@@ -0,0 +1,83 @@
+package authentication
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/go-launch-a-survey/settings"
+	"github.com/ONSdigital/go-launch-a-survey/surveys"
+)
+
+func TestDefaultsConfigLayersSurveyOverrides(t *testing.T) {
+	config := &DefaultsConfig{
+		Defaults: map[string]string{"region_code": "GB-WLS", "ru_name": "GLOBAL LTD."},
+		Surveys: []SurveyDefaults{
+			{EqID: "mbs", FormType: "0106", Values: map[string]string{"ru_name": "FORM LTD."}},
+			{EqID: "mbs", Values: map[string]string{"ru_name": "SURVEY LTD.", "trad_as": "SURVEY"}},
+			{EqID: "mbs", RURefRange: &RURefRange{From: "49900000001", To: "49900000009"}, PeriodIDFormat: "0601"},
+			{EqID: "qcas", Values: map[string]string{"ru_name": "OTHER LTD."}},
+		},
+	}
+
+	values := config.Values(surveys.LauncherSchema{EqID: "mbs", FormType: "0106"}, 1)
+
+	if values["region_code"] != "GB-WLS" {
+		t.Errorf("Expected global region_code GB-WLS but recieved %s", values["region_code"])
+	}
+	if values["ru_name"] != "FORM LTD." {
+		t.Errorf("Expected the form_type override to take precedence but recieved %s", values["ru_name"])
+	}
+	if values["trad_as"] != "SURVEY" {
+		t.Errorf("Expected the eq_id override for trad_as but recieved %s", values["trad_as"])
+	}
+	if !strings.HasPrefix(values["ru_ref"], "4990000000") {
+		t.Errorf("Expected ru_ref within the configured range but recieved %s", values["ru_ref"])
+	}
+	if len(values["period_id"]) != 4 {
+		t.Errorf("Expected a YYMM period_id but recieved %s", values["period_id"])
+	}
+}
+
+func TestReadDefaultsConfigRejectsInvalidRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "defaults.json")
+	ioutil.WriteFile(path, []byte(`{"surveys": [{"eq_id": "mbs", "ru_ref_range": {"from": "2", "to": "1"}}]}`), 0600)
+
+	if _, err := readDefaultsConfig(path); err == nil {
+		t.Errorf("Expected an error for an invalid ru_ref_range")
+	}
+}
+
+func TestLoadDefaultsConfigRetriesAFailedLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "defaults.json")
+	ioutil.WriteFile(path, []byte(`{"defaults": `), 0600)
+	service := NewService(settings.FromValues(map[string]string{"DEFAULTS_CONFIG_PATH": path}), nil, nil)
+	if _, err := service.LoadDefaultsConfig(); err == nil {
+		t.Fatalf("Expected an error for an invalid defaults config")
+	}
+
+	// Once the file is fixed it loads, and is kept even if it breaks again
+	ioutil.WriteFile(path, []byte(`{"defaults": {"ru_name": "ACME"}}`), 0600)
+	config, err := service.LoadDefaultsConfig()
+	if err != nil || config.Defaults["ru_name"] != "ACME" {
+		t.Fatalf("Expected the fixed defaults config but recieved %v, %v", config, err)
+	}
+	ioutil.WriteFile(path, []byte(`{"defaults": `), 0600)
+	if again, err := service.LoadDefaultsConfig(); err != nil || again != config {
+		t.Errorf("Expected the loaded defaults config to be kept but recieved %v, %v", again, err)
+	}
+}
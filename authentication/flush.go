@@ -0,0 +1,110 @@
+package authentication
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// FlusherRole is the role runner requires of a token before it will flush a survey's response downstream
+const FlusherRole = "flusher"
+
+// FlushItem identifies a survey response to flush. The schema is given by name or URL, and the response by
+// whichever of its case_id, response_id and ru_ref runner needs. Claims are any others the token should have.
+type FlushItem struct {
+	Schema     string            `json:"schema,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	CaseID     string            `json:"case_id,omitempty"`
+	ResponseID string            `json:"response_id,omitempty"`
+	RURef      string            `json:"ru_ref,omitempty"`
+	Claims     map[string]string `json:"claims,omitempty"`
+}
+
+// FlushClaims builds the claims of a flusher token for the item, using the default metadata values for any
+// required metadata it doesn't give
+func (s *Service) FlushClaims(item FlushItem) (map[string]interface{}, error) {
+	launcherSchema, err := s.FindLauncherSchema(item.Schema, item.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	for name, value := range item.Claims {
+		values.Set(name, value)
+	}
+	for name, value := range map[string]string{"case_id": item.CaseID, "response_id": item.ResponseID, "ru_ref": item.RURef} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+
+	claims, err := s.ClaimsFromDefaults(launcherSchema, values, DefaultTokenExpiry)
+	if err != nil {
+		return nil, err
+	}
+	claims["roles"] = []string{FlusherRole}
+	return claims, nil
+}
+
+// FlushClaimsFromLaunch makes the claims of an earlier launch into those of a flusher token for the same
+// response, with a new tx_id, jti and expiry
+func FlushClaimsFromLaunch(launchClaims map[string]interface{}) map[string]interface{} {
+	claims := make(map[string]interface{}, len(launchClaims))
+	for name, value := range launchClaims {
+		claims[name] = value
+	}
+
+	claims["roles"] = []string{FlusherRole}
+	claims["tx_id"] = uuid.NewV4().String()
+	for name, value := range GenerateJwtClaimsExpiringIn(DefaultTokenExpiry) {
+		claims[name] = value
+	}
+	return claims
+}
+
+// Flush POSTs a token with the claims to runner's flush endpoint from the launcher, rather than redirecting
+// the browser there as the launch form does. It returns runner's status code, and an error unless runner
+// flushed the response.
+func (s *Service) Flush(claims map[string]interface{}) (int, error) {
+	token, err := s.GenerateTokenFromClaims(claims)
+	if err != nil {
+		return 0, err
+	}
+
+	// The token is POSTed in the form rather than the URL, so it isn't in runner's access logs
+	flushURL, err := RunnerURL(s.config.Get("SURVEY_RUNNER_URL"), FlushEndpoint, "")
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := s.httpClient.PostForm(flushURL, url.Values{"token": {token}})
+	if err != nil {
+		return 0, &UpstreamError{URL: flushURL, Desc: fmt.Sprintf("Failed to contact %s", flushURL), From: unwrapURLError(err)}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		log.Printf("Flushed tx_id %v", claims["tx_id"])
+		return resp.StatusCode, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return resp.StatusCode, &UpstreamError{URL: flushURL, StatusCode: resp.StatusCode, Desc: "Runner has no response to flush"}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return resp.StatusCode, &UpstreamError{URL: flushURL, StatusCode: resp.StatusCode, Desc: "Runner rejected the flush token"}
+	}
+	return resp.StatusCode, &UpstreamError{URL: flushURL, StatusCode: resp.StatusCode, Desc: fmt.Sprintf("Runner failed to flush the response (%d)", resp.StatusCode)}
+}
+
+// unwrapURLError drops the url.Error wrapping a failed request, as its message repeats the URL already reported
+func unwrapURLError(err error) error {
+	if urlError, ok := err.(*url.Error); ok {
+		return urlError.Err
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package authentication
+
+import (
+	"testing"
+)
+
+func TestFlushClaimsFromLaunchKeepTheResponseIdentifiers(t *testing.T) {
+	launch := GenerateJwtClaims()
+	launch["tx_id"] = "launch-tx"
+	launch["roles"] = []string{"dumper"}
+	launch["ru_ref"] = "12345678901A"
+	launch["response_id"] = "resp-1"
+
+	claims := FlushClaimsFromLaunch(launch)
+
+	if claims["ru_ref"] != "12345678901A" || claims["response_id"] != "resp-1" {
+		t.Errorf("Expected the launch's identifiers but recieved %v", claims)
+	}
+	if roles, _ := claims["roles"].([]string); len(roles) != 1 || roles[0] != FlusherRole {
+		t.Errorf("Expected the flusher role but recieved %v", claims["roles"])
+	}
+	if claims["tx_id"] == "launch-tx" || claims["jti"] == launch["jti"] {
+		t.Errorf("Expected a new tx_id and jti")
+	}
+	if launch["roles"].([]string)[0] != "dumper" {
+		t.Errorf("Expected the launch's claims to be left alone")
+	}
+}
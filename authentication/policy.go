@@ -0,0 +1,213 @@
+package authentication
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// The actions a policy allows
+const (
+	ActionLaunch = "launch"
+	ActionFlush  = "flush"
+)
+
+// anything matches any user, group, role, target or action in a policy rule
+const anything = "*"
+
+// Principal is the launcher user asking for a token, with the groups they are in
+type Principal struct {
+	User   string
+	Groups []string
+}
+
+func (p Principal) String() string {
+	if p.User == "" {
+		return "anonymous user"
+	}
+	return p.User
+}
+
+// PolicyRule allows the users and the members of the groups it lists to take the actions in the targets,
+// with tokens having only the roles it lists. Any of them can be "*" to match anything. Roles must be
+// listed, as a rule without them would only allow tokens with no roles, so LoadPolicy rejects it.
+type PolicyRule struct {
+	Users   []string `yaml:"users" toml:"users"`
+	Groups  []string `yaml:"groups" toml:"groups"`
+	Roles   []string `yaml:"roles" toml:"roles"`
+	Targets []string `yaml:"targets" toml:"targets"`
+	Actions []string `yaml:"actions" toml:"actions"`
+}
+
+// Policy says which roles, runner targets and actions launcher users may use. Anything no rule allows is
+// denied, while a nil policy allows everything.
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules" toml:"rules"`
+}
+
+// PolicyError is returned when the policy doesn't allow a user's token
+type PolicyError struct {
+	Principal Principal
+	Target    string
+	Action    string
+	Roles     []string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s isn't allowed to %s into %s with roles %s", e.Principal, e.Action, e.Target, strings.Join(e.Roles, ", "))
+}
+
+// LoadPolicy reads the policy from a YAML, TOML or JSON file, or returns nil if no path is given
+func LoadPolicy(path string) (*Policy, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s: %w", path, err)
+	}
+
+	var policy Policy
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		err = yaml.Unmarshal(data, &policy)
+	case ".toml":
+		_, err = toml.Decode(string(data), &policy)
+	default:
+		err = fmt.Errorf("unsupported file extension %q; expected .yaml, .yml, .toml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+
+	for i, rule := range policy.Rules {
+		if len(rule.Users)+len(rule.Groups) == 0 {
+			return nil, fmt.Errorf("rules[%d] in %s has no users or groups", i, path)
+		}
+		if len(rule.Roles) == 0 {
+			return nil, fmt.Errorf("rules[%d] in %s has no roles; list \"*\" to allow any", i, path)
+		}
+		for _, action := range rule.Actions {
+			if action != ActionLaunch && action != ActionFlush && action != anything {
+				return nil, fmt.Errorf("rules[%d] in %s has unknown action %q; expected %s or %s", i, path, action, ActionLaunch, ActionFlush)
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// TargetNames lists the targets named by the policy's rules, so they can be checked against those configured
+func (p *Policy) TargetNames() []string {
+	var names []string
+	for _, rule := range p.Rules {
+		for _, target := range rule.Targets {
+			if target != anything {
+				names = append(names, target)
+			}
+		}
+	}
+	return names
+}
+
+// Check returns a PolicyError unless one rule allows the principal to take the action in the target with
+// all the roles. The flusher role lets a token flush at runner whatever it was made for, so it is only
+// allowed for the flush action, by a rule listing it by name rather than with "*".
+func (p *Policy) Check(principal Principal, target string, action string, roles []string) error {
+	if p == nil {
+		return nil
+	}
+
+	for _, rule := range p.Rules {
+		if !rule.appliesTo(principal) || !matches(rule.Targets, target) || !matches(rule.Actions, action) {
+			continue
+		}
+		allowed := true
+		for _, role := range roles {
+			if !rule.allowsRole(action, role) {
+				allowed = false
+			}
+		}
+		if allowed {
+			return nil
+		}
+	}
+	return &PolicyError{Principal: principal, Target: target, Action: action, Roles: roles}
+}
+
+// appliesTo checks whether the rule lists the principal or one of their groups
+func (r PolicyRule) appliesTo(principal Principal) bool {
+	if matches(r.Users, principal.User) {
+		return true
+	}
+	for _, group := range principal.Groups {
+		if matches(r.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsRole checks whether the rule allows a token for the action to have the role
+func (r PolicyRule) allowsRole(action string, role string) bool {
+	if strings.EqualFold(role, FlusherRole) {
+		if action != ActionFlush {
+			return false
+		}
+		for _, listed := range r.Roles {
+			if strings.EqualFold(listed, FlusherRole) {
+				return true
+			}
+		}
+		return false
+	}
+	return matches(r.Roles, role)
+}
+
+// matches checks whether the list includes the value, ignoring case, or "*"
+func matches(list []string, value string) bool {
+	for _, item := range list {
+		if item == anything || strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// claimRoles returns the roles claim, which is a list of roles or, from the launch form, a single role
+func claimRoles(claims map[string]interface{}) []string {
+	switch roles := claims["roles"].(type) {
+	case string:
+		return []string{roles}
+	case []string:
+		return roles
+	case []interface{}:
+		names := make([]string, 0, len(roles))
+		for _, role := range roles {
+			names = append(names, fmt.Sprint(role))
+		}
+		return names
+	}
+	return nil
+}
+
+// UsePolicy has the service check the tokens it makes for users against the policy, as the target with the name
+func (s *Service) UsePolicy(targetName string, policy *Policy) {
+	s.targetName = targetName
+	s.policy = policy
+}
+
+// Authorize checks the policy allows the principal to take the action with a token with the claims,
+// logging any attempt it denies
+func (s *Service) Authorize(principal Principal, action string, claims map[string]interface{}) error {
+	err := s.policy.Check(principal, s.targetName, action, claimRoles(claims))
+	if err != nil {
+		log.Println("WARN: Denied by policy:", err)
+	}
+	return err
+}
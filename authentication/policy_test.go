@@ -0,0 +1,102 @@
+package authentication
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyAllowsOnlyWhatARuleAllows(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{Groups: []string{"testers"}, Roles: []string{"dumper"}, Targets: []string{"staging"}, Actions: []string{ActionLaunch}},
+		{Groups: []string{"admins"}, Roles: []string{"*", FlusherRole}, Targets: []string{"*"}, Actions: []string{"*"}},
+		{Groups: []string{"launchers"}, Roles: []string{"*"}, Targets: []string{"*"}, Actions: []string{ActionLaunch, ActionFlush}},
+		{Users: []string{"Alice@example.com"}, Roles: []string{FlusherRole}, Targets: []string{"staging"}, Actions: []string{ActionFlush}},
+	}}
+	tester := Principal{User: "bob@example.com", Groups: []string{"testers"}}
+	admin := Principal{User: "carol@example.com", Groups: []string{"testers", "admins"}}
+	alice := Principal{User: "alice@example.com"}
+	launcher := Principal{User: "dave@example.com", Groups: []string{"launchers"}}
+
+	for _, test := range []struct {
+		principal Principal
+		target    string
+		action    string
+		roles     []string
+		allowed   bool
+	}{
+		{tester, "staging", ActionLaunch, nil, true},
+		{tester, "staging", ActionLaunch, []string{"dumper"}, true},
+		{tester, "staging", ActionLaunch, []string{"dumper", "flusher"}, false},
+		{tester, "preprod", ActionLaunch, nil, false},
+		{tester, "staging", ActionFlush, []string{FlusherRole}, false},
+		{admin, "preprod", ActionFlush, []string{FlusherRole}, true},
+		{admin, "preprod", ActionLaunch, []string{"flusher"}, false},
+		{launcher, "preprod", ActionLaunch, []string{"dumper", "anything"}, true},
+		{launcher, "preprod", ActionLaunch, []string{FlusherRole}, false},
+		{launcher, "preprod", ActionFlush, []string{FlusherRole}, false},
+		{alice, "staging", ActionFlush, []string{FlusherRole}, true},
+		{alice, "staging", ActionLaunch, nil, false},
+		{Principal{}, "staging", ActionLaunch, nil, false},
+	} {
+		err := policy.Check(test.principal, test.target, test.action, test.roles)
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf("Expected %s to %s into %s with roles %v to be allowed: %t, but recieved %v",
+				test.principal, test.action, test.target, test.roles, test.allowed, err)
+		}
+	}
+
+	var nilPolicy *Policy
+	if err := nilPolicy.Check(Principal{}, "staging", ActionFlush, []string{FlusherRole}); err != nil {
+		t.Errorf("Expected no policy to allow everything but recieved %s", err)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.yaml")
+	ioutil.WriteFile(path, []byte(`
+rules:
+  - groups: [testers]
+    roles: [dumper]
+    targets: [staging]
+    actions: [launch]
+`), 0600)
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("Failed to load policy: %s", err)
+	}
+	if len(policy.Rules) != 1 || policy.Rules[0].Groups[0] != "testers" || policy.Rules[0].Actions[0] != ActionLaunch {
+		t.Errorf("Expected the policy's rule but recieved %+v", policy.Rules)
+	}
+	if names := policy.TargetNames(); len(names) != 1 || names[0] != "staging" {
+		t.Errorf("Expected the policy to name target staging but recieved %v", names)
+	}
+
+	ioutil.WriteFile(path, []byte(`rules: [{groups: [testers], roles: [dumper], actions: [submit]}]`), 0600)
+	if _, err := LoadPolicy(path); err == nil || !strings.Contains(err.Error(), "submit") {
+		t.Errorf("Expected an error for an unknown action but recieved %v", err)
+	}
+
+	ioutil.WriteFile(path, []byte(`rules: [{roles: [dumper], actions: [launch]}]`), 0600)
+	if _, err := LoadPolicy(path); err == nil {
+		t.Errorf("Expected an error for a rule with no users or groups")
+	}
+
+	// A rule without roles would allow no token with any, so must say which it allows
+	ioutil.WriteFile(path, []byte(`rules: [{groups: [testers], targets: ["*"], actions: [launch]}]`), 0600)
+	if _, err := LoadPolicy(path); err == nil || !strings.Contains(err.Error(), "no roles") {
+		t.Errorf("Expected an error for a rule with no roles but recieved %v", err)
+	}
+
+	if policy, err := LoadPolicy(""); policy != nil || err != nil {
+		t.Errorf("Expected no policy without a path but recieved %v, %v", policy, err)
+	}
+}
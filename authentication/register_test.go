@@ -0,0 +1,73 @@
+package authentication
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ONSdigital/go-launch-a-survey/settings"
+	"github.com/ONSdigital/go-launch-a-survey/surveys"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// newFakeRegister serves one published questionnaire and its schema versions, only accepting
+// version requests made with the given method
+func newFakeRegister(t *testing.T, method string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/questionnaires/published":
+			w.Write([]byte(`[{"registry_id": "r2", "survey_id": "187", "form_type": "002", "title": "Ecommerce",
+				"lastPublished": "2019-12-12T08:55:27.731Z", "survey_version": "2", "eq_id": "ecommerce"}]`))
+		case "/questionnaires/versions":
+			w.Write([]byte(`[{"registry_id": "r1", "survey_version": "1"}, {"registry_id": "r2", "survey_version": "2"}]`))
+		case "/questionnaires/version":
+			if r.Method != method {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			body, _ := ioutil.ReadAll(r.Body)
+			var params surveys.ReqVersionBodyParams
+			if err := json.Unmarshal(body, &params); err != nil {
+				t.Errorf("Expected JSON body params but recieved %q", body)
+			}
+			if params != (surveys.ReqVersionBodyParams{SurveyID: "187", FormType: "002", SurveyVersion: "1"}) {
+				t.Errorf("Expected body params for version 1 but recieved %v", params)
+			}
+			if r.URL.Query().Get("survey_version") != "1" {
+				t.Errorf("Expected survey_version 1 in the query but recieved %s", r.URL.RawQuery)
+			}
+
+			w.Write([]byte(`{"eq_id": "ecommerce", "form_type": "002", "metadata": [{"name": "ru_name", "validator": "string"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestGetRequiredMetadataLoadsRegisterVersions(t *testing.T) {
+	for _, method := range []string{"GET", "POST"} {
+		server := newFakeRegister(t, method)
+
+		config := settings.FromValues(map[string]string{
+			"SURVEY_REGISTER_URL":            server.URL,
+			"SURVEY_REGISTER_VERSION_METHOD": method,
+		})
+		service := NewService(config, surveys.NewService(config, http.DefaultClient), http.DefaultClient)
+
+		launcherSchema, err := service.surveys.FindSurvey(surveys.RegisterSchemaID("187", "002", "1"))
+		if err != nil {
+			t.Fatalf("Error %s recieved, expected nil", err)
+		}
+
+		metadata, err := service.GetRequiredMetadata(launcherSchema, 1)
+		if err != nil {
+			t.Errorf("%s: Error %s recieved, expected nil", method, err)
+		} else if len(metadata) != 1 || metadata[0].Name != "ru_name" {
+			t.Errorf("%s: Expected the ru_name metadata but recieved %v", method, metadata)
+		}
+
+		server.Close()
+	}
+}
@@ -0,0 +1,31 @@
+package authentication
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Runner's endpoints which take a token
+const (
+	SessionEndpoint = "/session"
+	FlushEndpoint   = "/flush"
+)
+
+// RunnerURL builds the URL of runner's endpoint, adding the token to its query if one is given. Any path or
+// query of runner's URL is kept, so runner can be served under a path.
+func RunnerURL(runnerURL string, endpoint string, token string) (string, error) {
+	u, err := url.Parse(runnerURL)
+	if err != nil {
+		return "", fmt.Errorf("Invalid runner URL %q: %w", runnerURL, err)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + endpoint
+	u.RawPath = ""
+	if token != "" {
+		query := u.Query()
+		query.Set("token", token)
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), nil
+}
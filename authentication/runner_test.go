@@ -0,0 +1,32 @@
+package authentication
+
+import (
+	"testing"
+)
+
+func TestRunnerURL(t *testing.T) {
+	tests := []struct {
+		runnerURL string
+		token     string
+		expected  string
+	}{
+		{"http://localhost:5000", "abc.def", "http://localhost:5000/session?token=abc.def"},
+		{"http://localhost:5000/", "", "http://localhost:5000/session"},
+		{"http://localhost:8000/mock-runner", "abc", "http://localhost:8000/mock-runner/session?token=abc"},
+		{"https://runner.example.com/eq?lang=cy", "abc", "https://runner.example.com/eq/session?lang=cy&token=abc"},
+	}
+
+	for _, test := range tests {
+		runnerURL, err := RunnerURL(test.runnerURL, SessionEndpoint, test.token)
+		if err != nil {
+			t.Errorf("Error %s recieved for %s, expected nil", err, test.runnerURL)
+		}
+		if runnerURL != test.expected {
+			t.Errorf("Expected %s but recieved %s", test.expected, runnerURL)
+		}
+	}
+
+	if _, err := RunnerURL("http://[::1", SessionEndpoint, ""); err == nil {
+		t.Errorf("Expected an error for an invalid runner URL")
+	}
+}
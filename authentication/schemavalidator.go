@@ -0,0 +1,266 @@
+package authentication
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ONSdigital/go-launch-a-survey/lint"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// ErrNoSchemaValidator is returned when a schema is validated but SCHEMA_VALIDATOR_URL is not set
+var ErrNoSchemaValidator = errors.New("No schema validator is configured; set SCHEMA_VALIDATOR_URL")
+
+// SchemaError is a single problem the schema validator found in a schema
+type SchemaError struct {
+	Message string `json:"message"`
+
+	// Pointer is a JSON pointer, such as /sections/0/groups/1, to the part of the schema at fault
+	Pointer string `json:"pointer,omitempty"`
+
+	// Cause is the validator's suggestion of what caused the error, if it gave one
+	Cause string `json:"cause,omitempty"`
+}
+
+// SchemaValidationResult is the outcome of validating a schema, identified by the hash of its contents.
+// Validated is false when no schema validator is configured and only the lint checks were run.
+type SchemaValidationResult struct {
+	Valid     bool           `json:"valid"`
+	Validated bool           `json:"validated"`
+	Errors    []SchemaError  `json:"errors"`
+	Warnings  []lint.Warning `json:"warnings"`
+	Hash      string         `json:"hash"`
+	URL       string         `json:"url,omitempty"`
+	Cached    bool           `json:"cached"`
+}
+
+// SchemaHash returns the hash used to cache validation results for a schema
+func SchemaHash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// jsonPointer converts the path reported by the validator, either a pointer or a list of keys and
+// indexes, into a JSON pointer
+func jsonPointer(p interface{}) string {
+	switch p := p.(type) {
+	case string:
+		if p == "" || strings.HasPrefix(p, "/") {
+			return p
+		}
+		return "/" + strings.Replace(strings.Trim(p, "."), ".", "/", -1)
+	case []interface{}:
+		var pointer strings.Builder
+		for _, part := range p {
+			segment := fmt.Sprint(part)
+			if f, ok := part.(float64); ok {
+				segment = strconv.FormatFloat(f, 'f', -1, 64)
+			}
+			segment = strings.Replace(segment, "~", "~0", -1)
+			segment = strings.Replace(segment, "/", "~1", -1)
+			pointer.WriteString("/" + segment)
+		}
+		return pointer.String()
+	}
+	return ""
+}
+
+// parseSchemaErrors reads the errors from a validator response. Responses which are not in the
+// validator's format are reported as a single error holding the whole response.
+func parseSchemaErrors(responseBody []byte) []SchemaError {
+	var response struct {
+		Errors []map[string]interface{} `json:"errors"`
+	}
+	if err := json.Unmarshal(responseBody, &response); err != nil || len(response.Errors) == 0 {
+		return []SchemaError{{Message: strings.TrimSpace(string(responseBody))}}
+	}
+
+	schemaErrors := make([]SchemaError, 0, len(response.Errors))
+	for _, e := range response.Errors {
+		schemaError := SchemaError{}
+		schemaError.Message, _ = e["message"].(string)
+		schemaError.Cause, _ = e["predicted_cause"].(string)
+		for _, key := range []string{"pointer", "json_pointer", "path"} {
+			if pointer := jsonPointer(e[key]); pointer != "" {
+				schemaError.Pointer = pointer
+				break
+			}
+		}
+		if schemaError.Message == "" {
+			body, _ := json.Marshal(e)
+			schemaError.Message = string(body)
+		}
+		schemaErrors = append(schemaErrors, schemaError)
+	}
+
+	return schemaErrors
+}
+
+// validationCacheSize is how many validation results are kept. Anyone can POST schemas to /validate, so
+// the least recently used results are dropped rather than keeping every schema ever sent.
+const validationCacheSize = 256
+
+// validationCache keeps the most recently used validation results by schema hash
+type validationCache struct {
+	mutex   sync.Mutex
+	size    int
+	order   *list.List
+	results map[string]*list.Element
+}
+
+type cachedValidation struct {
+	hash   string
+	result *SchemaValidationResult
+}
+
+func newValidationCache(size int) *validationCache {
+	return &validationCache{size: size, order: list.New(), results: make(map[string]*list.Element)}
+}
+
+func (c *validationCache) get(hash string) (*SchemaValidationResult, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.results[hash]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedValidation).result, true
+}
+
+func (c *validationCache) add(hash string, result *SchemaValidationResult) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.results[hash]; ok {
+		element.Value.(*cachedValidation).result = result
+		c.order.MoveToFront(element)
+		return
+	}
+	c.results[hash] = c.order.PushFront(&cachedValidation{hash: hash, result: result})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.results, oldest.Value.(*cachedValidation).hash)
+	}
+}
+
+// ValidateSchema sends the schema to the schema validator. Recent results are cached by the hash of the
+// schema, so unchanged schemas are only sent to the validator once.
+func (s *Service) ValidateSchema(payload []byte) (*SchemaValidationResult, error) {
+	if s.config.Get("SCHEMA_VALIDATOR_URL") == "" {
+		return nil, ErrNoSchemaValidator
+	}
+
+	hash := SchemaHash(payload)
+
+	if cached, ok := s.validationCache.get(hash); ok {
+		result := *cached
+		result.Cached = true
+		return &result, nil
+	}
+
+	validateURL, err := url.Parse(s.config.Get("SCHEMA_VALIDATOR_URL"))
+	if err != nil {
+		return nil, &UpstreamError{URL: s.config.Get("SCHEMA_VALIDATOR_URL"), Desc: "Invalid schema validator URL", From: err}
+	}
+	validateURL.Path = path.Join(validateURL.Path, "validate")
+
+	log.Println("Validating schema: ", validateURL.String())
+
+	resp, err := s.httpClient.Post(validateURL.String(), "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, &UpstreamError{URL: validateURL.String(), Desc: "Failed to contact schema validator", From: err}
+	}
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, &UpstreamError{URL: validateURL.String(), StatusCode: resp.StatusCode, Desc: "Failed to read schema validator response", From: err}
+	}
+
+	result := &SchemaValidationResult{Validated: true, Hash: hash, Errors: []SchemaError{}}
+	switch resp.StatusCode {
+	case 200:
+		result.Valid = true
+	case 400:
+		result.Errors = parseSchemaErrors(responseBody)
+	default:
+		return nil, &UpstreamError{URL: validateURL.String(), StatusCode: resp.StatusCode, Desc: fmt.Sprintf("Schema validator returned unexpected status code %d: %s", resp.StatusCode, responseBody)}
+	}
+
+	s.validationCache.add(hash, result)
+
+	copied := *result
+	return &copied, nil
+}
+
+// CheckSchema lints the schema and sends it to the schema validator, if one is configured
+func (s *Service) CheckSchema(payload []byte) (*SchemaValidationResult, error) {
+	result, err := s.ValidateSchema(payload)
+	if err == ErrNoSchemaValidator {
+		result, err = &SchemaValidationResult{Valid: true, Errors: []SchemaError{}, Hash: SchemaHash(payload)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.Warnings = lint.Schema(payload)
+
+	return result, nil
+}
+
+// CheckSchemaFromURL loads the schema at the URL, lints it and sends it to the schema validator
+func (s *Service) CheckSchemaFromURL(schemaURL string) (*SchemaValidationResult, error) {
+	payload, err := s.fetchSchema(schemaURL)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.CheckSchema(payload)
+	if err != nil {
+		return nil, err
+	}
+	result.URL = schemaURL
+
+	return result, nil
+}
+
+// LintSchemaFromURL loads the schema at the URL and returns the lint warnings for it
+func (s *Service) LintSchemaFromURL(schemaURL string) ([]lint.Warning, error) {
+	payload, err := s.fetchSchema(schemaURL)
+	if err != nil {
+		return nil, err
+	}
+	return lint.Schema(payload), nil
+}
+
+// validateSchema returns a ValidationError if the schema validator rejects the schema. Schemas are
+// not validated when no validator is configured.
+func (s *Service) validateSchema(payload []byte) error {
+	result, err := s.ValidateSchema(payload)
+	if err == ErrNoSchemaValidator {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !result.Valid {
+		return &ValidationError{Desc: "Schema failed validation", Errors: result.Errors, Hash: result.Hash}
+	}
+
+	return nil
+}
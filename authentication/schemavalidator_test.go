@@ -0,0 +1,104 @@
+package authentication
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ONSdigital/go-launch-a-survey/settings"
+)
+
+func TestParseSchemaErrorsReadsJSONPointers(t *testing.T) {
+	schemaErrors := parseSchemaErrors([]byte(`{"success": false, "errors": [
+		{"message": "'id' is a required property", "path": ["sections", 0, "groups", 1]},
+		{"message": "Duplicate id found", "pointer": "/sections/0/id", "predicted_cause": "Copied block"},
+		{"message": "Bad answer", "path": "sections.0.answers"}
+	]}`))
+
+	expected := []SchemaError{
+		{Message: "'id' is a required property", Pointer: "/sections/0/groups/1"},
+		{Message: "Duplicate id found", Pointer: "/sections/0/id", Cause: "Copied block"},
+		{Message: "Bad answer", Pointer: "/sections/0/answers"},
+	}
+	if len(schemaErrors) != len(expected) {
+		t.Fatalf("Expected %v but recieved %v", expected, schemaErrors)
+	}
+	for i := range expected {
+		if schemaErrors[i] != expected[i] {
+			t.Errorf("Expected %v but recieved %v", expected[i], schemaErrors[i])
+		}
+	}
+}
+
+func TestParseSchemaErrorsKeepsUnrecognisedResponses(t *testing.T) {
+	schemaErrors := parseSchemaErrors([]byte("Schema is not valid JSON\n"))
+	if len(schemaErrors) != 1 || schemaErrors[0].Message != "Schema is not valid JSON" {
+		t.Errorf("Expected the response as a single error but recieved %v", schemaErrors)
+	}
+}
+
+func TestValidateSchemaCachesResultsByHash(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errors": [{"message": "Invalid schema", "path": ["metadata"]}]}`))
+	}))
+	defer server.Close()
+
+	service := NewService(settings.FromValues(map[string]string{"SCHEMA_VALIDATOR_URL": server.URL}), nil, http.DefaultClient)
+
+	first, err := service.ValidateSchema([]byte(`{"eq_id": "census"}`))
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	second, _ := service.ValidateSchema([]byte(`{"eq_id": "census"}`))
+	service.ValidateSchema([]byte(`{"eq_id": "mbs"}`))
+
+	if requests != 2 {
+		t.Errorf("Expected the validator to be called once per schema but it was called %d times", requests)
+	}
+	if first.Valid || first.Cached || !second.Cached || first.Hash != second.Hash {
+		t.Errorf("Expected an invalid result followed by the cached result but recieved %v and %v", first, second)
+	}
+
+	var validationError *ValidationError
+	if err := service.validateSchema([]byte(`{"eq_id": "census"}`)); !errors.As(err, &validationError) {
+		t.Fatalf("Expected a ValidationError but recieved %v", err)
+	}
+	if validationError.Errors[0].Pointer != "/metadata" {
+		t.Errorf("Expected the error pointer /metadata but recieved %v", validationError.Errors)
+	}
+}
+
+func TestValidationCacheDropsTheLeastRecentlyUsed(t *testing.T) {
+	cache := newValidationCache(2)
+	cache.add("a", &SchemaValidationResult{Hash: "a"})
+	cache.add("b", &SchemaValidationResult{Hash: "b"})
+	cache.get("a")
+	cache.add("c", &SchemaValidationResult{Hash: "c"})
+
+	if _, ok := cache.get("b"); ok {
+		t.Errorf("Expected the least recently used result to be dropped")
+	}
+	for _, hash := range []string{"a", "c"} {
+		if result, ok := cache.get(hash); !ok || result.Hash != hash {
+			t.Errorf("Expected the result for %s to be kept but recieved %v", hash, result)
+		}
+	}
+	if len(cache.results) != 2 || cache.order.Len() != 2 {
+		t.Errorf("Expected 2 cached results but recieved %d", len(cache.results))
+	}
+}
+
+func TestValidateSchemaWithoutValidator(t *testing.T) {
+	service := newTestService()
+
+	if _, err := service.ValidateSchema([]byte(`{}`)); err != ErrNoSchemaValidator {
+		t.Errorf("Expected ErrNoSchemaValidator but recieved %v", err)
+	}
+	if err := service.validateSchema([]byte(`{}`)); err != nil {
+		t.Errorf("Expected schemas to be launched unvalidated but recieved %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package authentication
+
+import (
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxSessionRedirects limits how many redirects are followed from runner's session endpoint
+const maxSessionRedirects = 5
+
+// maxErrorTextLength limits how much of runner's error page is reported
+const maxErrorTextLength = 500
+
+// RunnerSession is runner's response to launching a token, as seen by the launcher rather than the browser
+type RunnerSession struct {
+	TxID string `json:"tx_id"`
+
+	// StatusCode and Location are runner's response to the token at /session
+	StatusCode int    `json:"status_code"`
+	Location   string `json:"location,omitempty"`
+
+	// URL and FinalStatusCode are where following the redirects from /session ended up
+	URL             string `json:"url"`
+	FinalStatusCode int    `json:"final_status_code"`
+
+	// Cookies are runner's session cookies, which continue the survey from the URL
+	Cookies []SessionCookie `json:"cookies,omitempty"`
+
+	// Accepted is set if runner redirected to the survey, and Error is the text of runner's error page if it didn't
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SessionCookie is a cookie runner set when the session started
+type SessionCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// StartSession launches a token with the claims into runner from the launcher, following runner's redirects
+// from /session to the survey, and reports what runner did. An error is only returned if runner can't be reached.
+func (s *Service) StartSession(claims map[string]interface{}) (*RunnerSession, error) {
+	token, err := s.GenerateTokenFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &RunnerSession{TxID: ClaimString(claims, "tx_id")}
+	client := &http.Client{
+		Transport: s.httpClient.Transport,
+		Timeout:   s.httpClient.Timeout,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) == 1 {
+				session.Location = req.URL.String()
+			}
+			if len(via) > maxSessionRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+
+	// The token is POSTed in the form rather than the URL, so it isn't in runner's access logs
+	sessionURL, err := RunnerURL(s.config.Get("SURVEY_RUNNER_URL"), SessionEndpoint, "")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.PostForm(sessionURL, url.Values{"token": {token}})
+	if err != nil {
+		return nil, &UpstreamError{URL: sessionURL, Desc: "Failed to contact " + sessionURL, From: unwrapURLError(err)}
+	}
+	defer resp.Body.Close()
+
+	session.URL = sessionURL
+	session.FinalStatusCode = resp.StatusCode
+	session.StatusCode = resp.StatusCode
+	if resp.Request.Response != nil {
+		session.URL = resp.Request.URL.String()
+		first := resp.Request.Response
+		for first.Request.Response != nil {
+			first = first.Request.Response
+		}
+		session.StatusCode = first.StatusCode
+	}
+
+	for _, cookie := range jar.Cookies(resp.Request.URL) {
+		session.Cookies = append(session.Cookies, SessionCookie{Name: cookie.Name, Value: cookie.Value})
+	}
+
+	session.Accepted = session.Location != "" && resp.StatusCode < 400
+	if resp.StatusCode >= 400 {
+		session.Error = pageText(resp.Body)
+	} else {
+		io.Copy(ioutil.Discard, resp.Body)
+	}
+
+	return session, nil
+}
+
+var (
+	hiddenElements = regexp.MustCompile(`(?is)<(head|script|style)\b.*?</(head|script|style)>`)
+	tags           = regexp.MustCompile(`(?s)<[^>]*>`)
+	whitespace     = regexp.MustCompile(`\s+`)
+)
+
+// pageText reads the visible text of an HTML page, such as runner's error pages, shortened for reporting
+func pageText(body io.Reader) string {
+	page, _ := ioutil.ReadAll(io.LimitReader(body, 1<<20))
+
+	text := hiddenElements.ReplaceAllString(string(page), " ")
+	text = tags.ReplaceAllString(text, " ")
+	text = strings.TrimSpace(whitespace.ReplaceAllString(html.UnescapeString(text), " "))
+
+	if runes := []rune(text); len(runes) > maxErrorTextLength {
+		text = string(runes[:maxErrorTextLength]) + "..."
+	}
+	return text
+}
@@ -0,0 +1,20 @@
+package authentication
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPageTextReadsTheVisibleText(t *testing.T) {
+	page := `<html><head><title>Error</title><style>p { color: red }</style></head>
+		<body><h1>Sorry, there is a problem</h1><script>track()</script>
+		<p>The token couldn&#39;t be   decrypted</p></body></html>`
+
+	if text := pageText(strings.NewReader(page)); text != "Sorry, there is a problem The token couldn't be decrypted" {
+		t.Errorf("Expected the page's visible text but recieved %q", text)
+	}
+
+	if text := pageText(strings.NewReader(strings.Repeat("a", maxErrorTextLength+10))); len(text) != maxErrorTextLength+3 {
+		t.Errorf("Expected the text to be shortened but recieved %d characters", len(text))
+	}
+}
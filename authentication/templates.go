@@ -0,0 +1,185 @@
+package authentication
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ONSdigital/go-launch-a-survey/generators"
+)
+
+// ClaimTemplateData is the data available to claim templates
+type ClaimTemplateData struct {
+	// Index counts the tokens in a batch from 0, and is 0 for a single launch
+	Index int
+	Seed  int64
+}
+
+// templateTime is a time which claim templates output as a YYYY-MM-DD date
+type templateTime struct {
+	time.Time
+}
+
+func (t templateTime) String() string {
+	return t.Format("2006-01-02")
+}
+
+// addMonths adds the months to the time, keeping to the last day of the month rather than
+// overflowing into the next, so 31 January plus one month is 28 or 29 February
+func addMonths(months int, t templateTime) templateTime {
+	firstOfMonth := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	day := t.Day()
+	if lastDay := firstOfMonth.AddDate(0, 1, -1).Day(); day > lastDay {
+		day = lastDay
+	}
+	return templateTime{firstOfMonth.AddDate(0, 0, day-1)}
+}
+
+// maxTemplateDigits limits the length of randomDigits, as anyone who can launch writes the templates
+const maxTemplateDigits = 64
+
+// claimTemplateFuncs are the functions available to claim templates. Each evaluator gets its own
+// set, so the random values are fixed by the seed and differ between the claims of one token.
+func claimTemplateFuncs(data ClaimTemplateData, now time.Time) template.FuncMap {
+	seed := data.Seed
+	if seed != 0 {
+		// Offset the seed so templates don't repeat the values generated for the defaults
+		seed = seed*31 + 7
+	}
+	generator := generators.New(seed)
+
+	return template.FuncMap{
+		"now": func() templateTime {
+			return templateTime{now}
+		},
+		"addDays": func(days int, t templateTime) templateTime {
+			return templateTime{t.AddDate(0, 0, days)}
+		},
+		"addMonths": addMonths,
+		"startOfMonth": func(t templateTime) templateTime {
+			return templateTime{time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())}
+		},
+		"endOfMonth": func(t templateTime) templateTime {
+			return templateTime{time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location())}
+		},
+		"format": func(layout string, t templateTime) string {
+			return t.Format(layout)
+		},
+		"uuid": generator.UUID,
+		"randomDigits": func(n int) (string, error) {
+			if n < 0 || n > maxTemplateDigits {
+				return "", fmt.Errorf("randomDigits length %d must be between 0 and %d", n, maxTemplateDigits)
+			}
+			return generator.Digits(n), nil
+		},
+		"seq": func(start int64) (string, error) {
+			if start < 0 || start > math.MaxInt64-int64(data.Index) {
+				return "", fmt.Errorf("seq start %d must be between 0 and %d", start, int64(math.MaxInt64)-int64(data.Index))
+			}
+			return strconv.FormatInt(start+int64(data.Index), 10), nil
+		},
+		"checkLetter": func(ruRef string) (string, error) {
+			letter, err := generators.CheckLetter(ruRef)
+			if err != nil {
+				return "", err
+			}
+			return ruRef + letter, nil
+		},
+	}
+}
+
+// claimTemplateEvaluator evaluates claim values as templates, collecting an error for each claim which fails
+type claimTemplateEvaluator struct {
+	data   ClaimTemplateData
+	funcs  template.FuncMap
+	errors []FieldError
+}
+
+func newClaimTemplateEvaluator(data ClaimTemplateData) *claimTemplateEvaluator {
+	return &claimTemplateEvaluator{
+		data:  data,
+		funcs: claimTemplateFuncs(data, time.Now()),
+	}
+}
+
+func isClaimTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+func parseClaimTemplate(name string, value string, funcs template.FuncMap) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Funcs(funcs).Parse(value)
+}
+
+func (e *claimTemplateEvaluator) evaluate(name string, value string) string {
+	if !isClaimTemplate(value) {
+		return value
+	}
+
+	t, err := parseClaimTemplate(name, value, e.funcs)
+	if err == nil {
+		var output bytes.Buffer
+		if err = t.Execute(&output, e.data); err == nil {
+			return output.String()
+		}
+	}
+
+	e.errors = append(e.errors, FieldError{Name: name, Value: value, Message: "has an invalid template: " + err.Error()})
+	return value
+}
+
+func (e *claimTemplateEvaluator) values(values url.Values) url.Values {
+	evaluated := make(url.Values, len(values))
+	for name, vs := range values {
+		for _, v := range vs {
+			evaluated.Add(name, e.evaluate(name, v))
+		}
+	}
+	return evaluated
+}
+
+func (e *claimTemplateEvaluator) defaults(defaults map[string]string) map[string]string {
+	evaluated := make(map[string]string, len(defaults))
+	for name, v := range defaults {
+		evaluated[name] = e.evaluate(name, v)
+	}
+	return evaluated
+}
+
+func (e *claimTemplateEvaluator) err() error {
+	if len(e.errors) == 0 {
+		return nil
+	}
+	return &MetadataValidationError{Fields: e.errors}
+}
+
+// evaluateClaimTemplates returns the values with any templates evaluated
+func evaluateClaimTemplates(values url.Values, data ClaimTemplateData) (url.Values, error) {
+	evaluator := newClaimTemplateEvaluator(data)
+	evaluated := evaluator.values(values)
+	return evaluated, evaluator.err()
+}
+
+// checkClaimTemplates reports any values which are not valid templates, without evaluating them
+func checkClaimTemplates(values url.Values) error {
+	funcs := claimTemplateFuncs(ClaimTemplateData{}, time.Now())
+	var errors []FieldError
+	for name, vs := range values {
+		for _, v := range vs {
+			if !isClaimTemplate(v) {
+				continue
+			}
+			if _, err := parseClaimTemplate(name, v, funcs); err != nil {
+				errors = append(errors, FieldError{Name: name, Value: v, Message: "has an invalid template: " + err.Error()})
+			}
+		}
+	}
+	if len(errors) == 0 {
+		return nil
+	}
+	return &MetadataValidationError{Fields: errors}
+}
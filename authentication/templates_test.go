@@ -0,0 +1,105 @@
+package authentication
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/go-launch-a-survey/surveys"
+)
+
+func evaluateAt(t *testing.T, value string, data ClaimTemplateData, now time.Time) string {
+	evaluator := &claimTemplateEvaluator{data: data, funcs: claimTemplateFuncs(data, now)}
+	output := evaluator.evaluate("claim", value)
+	if err := evaluator.err(); err != nil {
+		t.Fatalf("Error %s recieved evaluating %q, expected nil", err, value)
+	}
+	return output
+}
+
+func TestClaimTemplateDateFunctions(t *testing.T) {
+	now := time.Date(2020, time.January, 30, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]string{
+		"{{now}}":                                           "2020-01-30",
+		"{{now | addDays 3}}":                               "2020-02-02",
+		"{{now | addDays -30}}":                             "2019-12-31",
+		"{{now | addMonths 1 | startOfMonth}}":              "2020-02-01",
+		"{{now | addMonths 1 | endOfMonth}}":                "2020-02-29",
+		"{{now | format \"January 2006\"}}":                 "January 2020",
+		"period {{now | startOfMonth | format \"200601\"}}": "period 202001",
+	}
+
+	for template, expected := range tests {
+		if output := evaluateAt(t, template, ClaimTemplateData{}, now); output != expected {
+			t.Errorf("Expected %q to evaluate to %q but recieved %q", template, expected, output)
+		}
+	}
+}
+
+func TestClaimTemplateSequences(t *testing.T) {
+	now := time.Now()
+
+	if output := evaluateAt(t, "{{seq 49900000001}}", ClaimTemplateData{Index: 4}, now); output != "49900000005" {
+		t.Errorf("Expected seq to add the index but recieved %q", output)
+	}
+
+	if output := evaluateAt(t, "{{seq 49900000001 | checkLetter}}", ClaimTemplateData{}, now); len(output) != 12 || output[:11] != "49900000001" {
+		t.Errorf("Expected checkLetter to append a check letter but recieved %q", output)
+	}
+}
+
+func TestClaimTemplateRandomValuesFollowTheSeed(t *testing.T) {
+	now := time.Now()
+	value := "{{uuid}} {{randomDigits 6}}"
+
+	first := evaluateAt(t, value, ClaimTemplateData{Seed: 42}, now)
+	second := evaluateAt(t, value, ClaimTemplateData{Seed: 42}, now)
+	if first != second {
+		t.Errorf("Expected the same seed to give the same values but recieved %q and %q", first, second)
+	}
+
+	if other := evaluateAt(t, value, ClaimTemplateData{Seed: 43}, now); other == first {
+		t.Errorf("Expected a different seed to give different values but recieved %q", other)
+	}
+
+	if len(first) != 36+1+6 {
+		t.Errorf("Expected a uuid and six digits but recieved %q", first)
+	}
+}
+
+func TestEvaluateClaimTemplatesReportsEachInvalidClaim(t *testing.T) {
+	values := url.Values{
+		"ru_ref":     {"{{checkLetter \"123\"}}"},
+		"period_id":  {"{{now | addDays}}"},
+		"trad_as":    {"Static"},
+		"employment": {"{{unknown}}"},
+		"ru_name":    {"{{randomDigits 1000000000}}"},
+		"case_ref":   {"{{seq -1}}"},
+	}
+
+	_, err := evaluateClaimTemplates(values, ClaimTemplateData{})
+
+	var metadataError *MetadataValidationError
+	if !errors.As(err, &metadataError) {
+		t.Fatalf("Expected a MetadataValidationError but recieved %v", err)
+	}
+	if len(metadataError.Fields) != 5 {
+		t.Errorf("Expected 5 field errors but recieved %v", metadataError.Fields)
+	}
+}
+
+func TestClaimsFromMetadataEvaluatesDefaults(t *testing.T) {
+	requiredMetadata := []Metadata{{Name: "ref_p_end_date", Validator: "date"}}
+	defaults := map[string]string{"ref_p_end_date": "{{now | endOfMonth}}"}
+
+	claims, err := claimsFromMetadata(surveys.LauncherSchema{EqID: "mbs", FormType: "0106"}, requiredMetadata, defaults, url.Values{}, ClaimTemplateData{}, DefaultTokenExpiry)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+
+	if claims["ref_p_end_date"] == defaults["ref_p_end_date"] {
+		t.Errorf("Expected the default to be evaluated but recieved %v", claims["ref_p_end_date"])
+	}
+}
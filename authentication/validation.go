@@ -0,0 +1,118 @@
+package authentication
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LaunchAnywayField is the form/query field which skips metadata validation so invalid
+// tokens can be produced for negative testing
+const LaunchAnywayField = "launch_anyway"
+
+var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// FieldError describes a single metadata value that failed its schema validator
+type FieldError struct {
+	Name      string `json:"name"`
+	Validator string `json:"validator"`
+	Value     string `json:"value"`
+	Message   string `json:"message"`
+}
+
+// MetadataValidationError describes a set of metadata values that failed validation
+type MetadataValidationError struct {
+	Fields []FieldError `json:"errors"`
+}
+
+func (e *MetadataValidationError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Name + ": " + field.Message
+	}
+	return "Metadata failed validation: " + strings.Join(messages, "; ")
+}
+
+// ValidateMetadataValue checks a single value against the named schema validator, returning a
+// description of the problem or an empty string if the value is valid
+func ValidateMetadataValue(validator string, value string) string {
+	switch validator {
+	case "boolean":
+		if value == "" || value == "on" {
+			return ""
+		}
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "must be true or false"
+		}
+	case "date":
+		if value == "" {
+			return "is required"
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return "must be a date in the format YYYY-MM-DD"
+		}
+	case "uuid":
+		if value == "" {
+			return "is required"
+		}
+		if !uuidRegex.MatchString(value) {
+			return "must be a UUID"
+		}
+	case "integer":
+		if value == "" {
+			return "is required"
+		}
+		if _, err := strconv.Atoi(value); err != nil {
+			return "must be a whole number"
+		}
+	default:
+		if value == "" {
+			return "is required"
+		}
+	}
+	return ""
+}
+
+// ValidateMetadata checks the submitted values against each metadata entry's validator
+func ValidateMetadata(metadata []Metadata, values url.Values) error {
+	var fields []FieldError
+
+	for _, entry := range metadata {
+		value := values.Get(entry.Name)
+		if entry.Optional && value == "" {
+			continue
+		}
+		if message := ValidateMetadataValue(entry.Validator, value); message != "" {
+			fields = append(fields, FieldError{
+				Name:      entry.Name,
+				Validator: entry.Validator,
+				Value:     value,
+				Message:   message,
+			})
+		}
+	}
+
+	if len(fields) > 0 {
+		return &MetadataValidationError{Fields: fields}
+	}
+
+	return nil
+}
+
+func skipValidation(values url.Values) bool {
+	return getBooleanOrDefault(LaunchAnywayField, values, false) || values.Get(LaunchAnywayField) == "on"
+}
+
+func claimValues(claims map[string]interface{}) url.Values {
+	values := url.Values{}
+	for key, value := range claims {
+		values.Set(key, fmt.Sprint(value))
+	}
+	return values
+}
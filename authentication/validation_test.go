@@ -0,0 +1,69 @@
+package authentication
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestValidateMetadataValue(t *testing.T) {
+	cases := []struct {
+		validator string
+		value     string
+		valid     bool
+	}{
+		{"date", "2016-05-31", true},
+		{"date", "31/05/2016", false},
+		{"date", "2016-02-30", false},
+		{"uuid", "0d3e4e7f-7c0b-4b3b-9a8e-5a4fc0a1b2c3", true},
+		{"uuid", "not-a-uuid", false},
+		{"boolean", "true", true},
+		{"boolean", "on", true},
+		{"boolean", "", true},
+		{"boolean", "maybe", false},
+		{"string", "ESSENTIAL ENTERPRISE LTD.", true},
+		{"string", "", false},
+	}
+
+	for _, c := range cases {
+		message := ValidateMetadataValue(c.validator, c.value)
+		if (message == "") != c.valid {
+			t.Errorf("ValidateMetadataValue(%q, %q) = %q, expected valid: %v", c.validator, c.value, message, c.valid)
+		}
+	}
+}
+
+func TestValidateMetadataReturnsFieldErrors(t *testing.T) {
+	metadata := []Metadata{
+		{Name: "ref_p_start_date", Validator: "date"},
+		{Name: "ru_name", Validator: "string"},
+	}
+	values := url.Values{"ref_p_start_date": {"01/05/2016"}, "ru_name": {"ESSENTIAL ENTERPRISE LTD."}}
+
+	err := ValidateMetadata(metadata, values)
+
+	var metadataError *MetadataValidationError
+	if !errors.As(err, &metadataError) {
+		t.Fatalf("Expected a MetadataValidationError but recieved %v", err)
+	}
+	if len(metadataError.Fields) != 1 || metadataError.Fields[0].Name != "ref_p_start_date" {
+		t.Errorf("Expected a single error for ref_p_start_date but recieved %v", metadataError.Fields)
+	}
+}
+
+func TestSkipValidation(t *testing.T) {
+	if !skipValidation(url.Values{LaunchAnywayField: {"on"}}) {
+		t.Errorf("Expected a ticked launch anyway checkbox to skip validation")
+	}
+	if skipValidation(url.Values{}) {
+		t.Errorf("Expected validation to run by default")
+	}
+}
+
+func TestValidateMetadataSkipsUnsetOptionalFields(t *testing.T) {
+	metadata := []Metadata{{Name: "trad_as", Validator: "string", Optional: true}}
+
+	if err := ValidateMetadata(metadata, url.Values{}); err != nil {
+		t.Errorf("Error %s recieved, expected nil", err)
+	}
+}
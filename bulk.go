@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+	"github.com/ONSdigital/go-launch-a-survey/generators"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// maxBulkCount limits the number of tokens a single bulk request can generate
+const maxBulkCount = 100000
+
+// bulkFlushInterval is how many tokens are written between flushes of the output
+const bulkFlushInterval = 100
+
+var bulkCSVHeader = []string{"index", "token", "url", "case_id", "response_id", "ru_ref", "collection_exercise_sid"}
+
+// bulkWriter writes generated tokens as CSV or newline delimited JSON
+type bulkWriter struct {
+	format string
+	csv    *csv.Writer
+	json   io.Writer
+}
+
+// checkBulkFormat returns an error unless the format is one a bulkWriter can write
+func checkBulkFormat(format string) error {
+	if format != "csv" && format != "ndjson" {
+		return fmt.Errorf("Unknown format %q; expected csv or ndjson", format)
+	}
+	return nil
+}
+
+func newBulkWriter(w io.Writer, format string) (*bulkWriter, error) {
+	if err := checkBulkFormat(format); err != nil {
+		return nil, err
+	}
+	if format == "ndjson" {
+		return &bulkWriter{format: format, json: w}, nil
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(bulkCSVHeader); err != nil {
+		return nil, err
+	}
+	return &bulkWriter{format: format, csv: writer}, nil
+}
+
+// write writes the token, which for CSV is buffered until the writer is flushed
+func (b *bulkWriter) write(token authentication.BulkToken) error {
+	if b.csv != nil {
+		return b.csv.Write([]string{strconv.Itoa(token.Index), token.Token, token.URL, token.CaseID, token.ResponseID, token.RURef, token.CollectionExerciseSID})
+	}
+
+	line, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	_, err = b.json.Write(append(line, '\n'))
+	return err
+}
+
+// flush writes any buffered tokens
+func (b *bulkWriter) flush() error {
+	if b.csv != nil {
+		b.csv.Flush()
+		return b.csv.Error()
+	}
+	return nil
+}
+
+// parseClaims reads claims given as name=value
+func parseClaims(claims []string) (url.Values, error) {
+	values := claimFlags{}
+	for _, claim := range claims {
+		if err := values.Set(claim); err != nil {
+			return nil, err
+		}
+	}
+	return url.Values(values), nil
+}
+
+// bulkHandler streams tokens for the schema or url parameter. count, seed, exp, format (csv or ndjson)
+// and repeated claim=name=value parameters control the tokens generated.
+func (l *launcher) bulkHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := l.target(r)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
+	count, err := strconv.Atoi(r.FormValue("count"))
+	if err != nil || count < 1 || count > maxBulkCount {
+		http.Error(w, fmt.Sprintf("count must be a number from 1 to %d", maxBulkCount), http.StatusBadRequest)
+		return
+	}
+
+	expiry := authentication.DefaultTokenExpiry
+	if exp := r.FormValue("exp"); exp != "" {
+		if expiry, err = time.ParseDuration(exp); err != nil {
+			http.Error(w, fmt.Sprintf("exp is not a valid duration: %q", exp), http.StatusBadRequest)
+			return
+		}
+	}
+
+	claims, err := parseClaims(r.Form["claim"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if err := checkBulkFormat(format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	launcherSchema, err := t.authentication.FindLauncherSchema(r.FormValue("schema"), r.FormValue("url"))
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
+	req := authentication.BulkRequest{
+		LauncherSchema: launcherSchema,
+		Count:          count,
+		Claims:         claims,
+		Seed:           generators.New(generators.ParseSeed(r.FormValue(authentication.SeedField))).Seed,
+		Expiry:         expiry,
+		Principal:      requestPrincipal(r),
+	}
+
+	log.Printf("Generating %d tokens for %s into %s%s", count, launcherSchema.Name, t.name, byUser(r))
+
+	// The response starts with the first token, so earlier errors can still be reported
+	var writer *bulkWriter
+	flusher, _ := w.(http.Flusher)
+	err = t.authentication.GenerateBulkTokens(req, func(token authentication.BulkToken) error {
+		if writer == nil {
+			contentType := "application/x-ndjson"
+			if format == "csv" {
+				contentType = "text/csv"
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("X-Seed", strconv.FormatInt(req.Seed, 10))
+
+			if writer, err = newBulkWriter(w, format); err != nil {
+				return err
+			}
+		}
+		if err := writer.write(token); err != nil {
+			return err
+		}
+		if token.Index%bulkFlushInterval == 0 {
+			if err := writer.flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil && writer == nil {
+		l.writeError(w, r, err)
+		return
+	}
+	if err == nil {
+		err = writer.flush()
+	}
+	if err != nil {
+		// The response has started, so the error can only be logged
+		log.Println("Bulk generation failed:", err)
+	}
+}
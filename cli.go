@@ -0,0 +1,298 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+	"github.com/ONSdigital/go-launch-a-survey/clients"
+	"github.com/ONSdigital/go-launch-a-survey/generators"
+	"github.com/ONSdigital/go-launch-a-survey/lint"
+	"github.com/ONSdigital/go-launch-a-survey/settings"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// command is a subcommand run from the command line instead of starting the server
+type command struct {
+	summary string
+	run     func(config *settings.Config, args []string, stdout, stderr io.Writer) int
+}
+
+var commands = map[string]command{
+	"lint":  {summary: "Check schema files or URLs for common mistakes", run: runLint},
+	"token": {summary: "Generate a launch token for a schema", run: runToken},
+	"bulk":  {summary: "Generate many distinct launch tokens for load testing", run: runBulk},
+}
+
+// runCommand runs the named subcommand, returning its exit code
+func runCommand(config *settings.Config, args []string, stdout, stderr io.Writer) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command %q. Run with no arguments to start the launcher, or use one of:\n", args[0])
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stderr, "  %-10s %s\n", name, commands[name].summary)
+		}
+		return 2
+	}
+	return cmd.run(config, args[1:], stdout, stderr)
+}
+
+// readSchema reads a schema from a file, or from runner or the register when given a URL
+func readSchema(httpClient *http.Client, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return ioutil.ReadFile(source)
+	}
+
+	resp, err := httpClient.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s returned status code %d", source, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func runLint(config *settings.Config, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "Output the warnings as JSON")
+	strict := flags.Bool("strict", false, "Exit with status 1 if there are any warnings")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: go-launch-a-survey lint [-json] [-strict] <schema file or URL>...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	httpClient := clients.NewHTTPClient(config)
+	results := make(map[string][]lint.Warning)
+	warned, failed := false, false
+
+	for _, source := range flags.Args() {
+		payload, err := readSchema(httpClient, source)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", source, err)
+			failed = true
+			continue
+		}
+
+		warnings := lint.Schema(payload)
+		results[source] = warnings
+		warned = warned || len(warnings) > 0
+
+		if !*asJSON {
+			for _, warning := range warnings {
+				fmt.Fprintf(stdout, "%s#%s: %s\n", source, warning.Pointer, warning.Message)
+			}
+		}
+	}
+
+	if *asJSON {
+		output, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Fprintln(stdout, string(output))
+	}
+
+	if failed || (*strict && warned) {
+		return 1
+	}
+	return 0
+}
+
+// claimFlags collects repeated -claim name=value flags
+type claimFlags url.Values
+
+func (c claimFlags) String() string {
+	return url.Values(c).Encode()
+}
+
+func (c claimFlags) Set(claim string) error {
+	parts := strings.SplitN(claim, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("claims must be given as name=value, not %q", claim)
+	}
+	url.Values(c).Add(parts[0], parts[1])
+	return nil
+}
+
+// tokenOutput is the JSON output of the token command
+type tokenOutput struct {
+	Token  string                 `json:"token"`
+	URL    string                 `json:"url"`
+	Claims map[string]interface{} `json:"claims"`
+}
+
+func runToken(config *settings.Config, args []string, stdout, stderr io.Writer) int {
+	claims := claimFlags{}
+
+	flags := flag.NewFlagSet("token", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	schemaID := flags.String("schema", "", "ID of a schema from runner or the register, e.g. census_household.json")
+	schemaURL := flags.String("url", "", "URL of a schema to launch instead of -schema")
+	targetName := flags.String("target", "", "Runner target to launch into; defaults to the first target")
+	expiry := flags.Duration("exp", authentication.DefaultTokenExpiry, "How long the token is valid for")
+	seed := flags.Int64("seed", 0, "Seed for the generated metadata values; random if not given")
+	output := flags.String("output", "token", "What to output: token, url or json")
+	accountServiceURL := flags.String("account-service-url", "http://localhost:"+config.Get("GO_LAUNCH_A_SURVEY_LISTEN_PORT"), "Account service URL claims")
+	flags.Var(claims, "claim", "A claim as name=value; may be repeated")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: go-launch-a-survey token (-schema <id> | -url <schema url>) [-claim name=value]... [-exp 30m] [-output token|url|json]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if (*schemaID == "") == (*schemaURL == "") || (*output != "token" && *output != "url" && *output != "json") {
+		flags.Usage()
+		return 2
+	}
+
+	l, err := newLauncher(config)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	t, err := l.targetNamed(*targetName)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	values := url.Values(claims)
+	for _, name := range []string{"account_service_url", "account_service_log_out_url"} {
+		if values.Get(name) == "" {
+			values.Set(name, *accountServiceURL)
+		}
+	}
+	if *seed != 0 {
+		values.Set(authentication.SeedField, fmt.Sprint(*seed))
+	}
+
+	launcherSchema, err := t.authentication.FindLauncherSchema(*schemaID, *schemaURL)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	tokenClaims, err := t.authentication.ClaimsFromDefaults(launcherSchema, values, *expiry)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	token, err := t.authentication.GenerateTokenFromClaims(tokenClaims)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	sessionURL, err := authentication.RunnerURL(t.config.Get("SURVEY_RUNNER_URL"), authentication.SessionEndpoint, token)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	switch *output {
+	case "url":
+		fmt.Fprintln(stdout, sessionURL)
+	case "json":
+		body, _ := json.MarshalIndent(tokenOutput{Token: token, URL: sessionURL, Claims: tokenClaims}, "", "  ")
+		fmt.Fprintln(stdout, string(body))
+	default:
+		fmt.Fprintln(stdout, token)
+	}
+
+	return 0
+}
+
+func runBulk(config *settings.Config, args []string, stdout, stderr io.Writer) int {
+	claims := claimFlags{}
+
+	flags := flag.NewFlagSet("bulk", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	schemaID := flags.String("schema", "", "ID of a schema from runner or the register, e.g. census_household.json")
+	schemaURL := flags.String("url", "", "URL of a schema to launch instead of -schema")
+	targetName := flags.String("target", "", "Runner target to launch into; defaults to the first target")
+	count := flags.Int("count", 100, "Number of tokens to generate")
+	expiry := flags.Duration("exp", authentication.DefaultTokenExpiry, "How long the tokens are valid for")
+	seed := flags.Int64("seed", 0, "Seed for the generated metadata values; random if not given")
+	format := flags.String("format", "csv", "Output format: csv or ndjson")
+	workers := flags.Int("workers", 0, "Number of tokens to generate at once; defaults to the number of CPUs")
+	flags.Var(claims, "claim", "A claim as name=value, where the value is a template; may be repeated")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: go-launch-a-survey bulk (-schema <id> | -url <schema url>) -count <n> [-claim name=value]... [-format csv|ndjson]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if (*schemaID == "") == (*schemaURL == "") || *count < 1 {
+		flags.Usage()
+		return 2
+	}
+	if err := checkBulkFormat(*format); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	l, err := newLauncher(config)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	t, err := l.targetNamed(*targetName)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	launcherSchema, err := t.authentication.FindLauncherSchema(*schemaID, *schemaURL)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	writer, err := newBulkWriter(stdout, *format)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	req := authentication.BulkRequest{
+		LauncherSchema: launcherSchema,
+		Count:          *count,
+		Claims:         url.Values(claims),
+		Seed:           generators.New(*seed).Seed,
+		Expiry:         *expiry,
+		Workers:        *workers,
+	}
+	fmt.Fprintln(stderr, "Generating", req.Count, "tokens with seed", req.Seed)
+
+	err = t.authentication.GenerateBulkTokens(req, writer.write)
+	if flushErr := writer.flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -1,15 +1,22 @@
 package clients
 
 import (
+	"log"
 	"net/http"
 	"time"
+
+	"github.com/ONSdigital/go-launch-a-survey/settings"
 )
 
-var httpClient = &http.Client{
-	Timeout: time.Duration(5) * time.Second,
-}
+// NewHTTPClient returns an HttpClient using the timeout from the settings
+func NewHTTPClient(config *settings.Config) *http.Client {
+	timeout, err := config.GetDuration("HTTP_CLIENT_TIMEOUT")
+	if err != nil {
+		log.Println(err)
+		timeout = time.Duration(5) * time.Second
+	}
 
-// GetHTTPClient returns a single HttpClient for use across the app
-func GetHTTPClient() (*http.Client)  {
-	return httpClient
-}
\ No newline at end of file
+	return &http.Client{
+		Timeout: timeout,
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+)
+
+// csrfCookie holds the browser's CSRF token, which pages on other sites can't read, or send with their
+// requests as the cookie is SameSite
+const csrfCookie = "launcher_csrf"
+
+// csrfField is the form field the launcher's forms send the CSRF token back in
+const csrfField = "csrf_token"
+
+// csrfHeader is the header scripts POSTing JSON or schemas send the CSRF token back in, having read the
+// cookie from one of the launcher's pages
+const csrfHeader = "X-CSRF-Token"
+
+// errCSRF is returned for a form POST which didn't come from one of the launcher's pages
+var errCSRF = &accessError{StatusCode: http.StatusForbidden, Desc: "Missing or invalid CSRF token; reload the page and try again"}
+
+// csrfToken returns the browser's CSRF token, giving it one if it hasn't got one yet. It must be called
+// before the response is written, as it may set the cookie.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	token, err := randomString()
+	if err != nil {
+		log.Println("WARN: Failed to generate a CSRF token:", err)
+		return ""
+	}
+	setCookie(w, r, csrfCookie, token, 0)
+	return token
+}
+
+// requireCSRFToken only passes on POSTs which send back the browser's CSRF token, in the form or the
+// X-CSRF-Token header, so a page on another site can't launch, flush or make tokens through a tester's
+// browser. A page can POST any body, JSON included, so only API clients don't need it, as browsers never
+// send the API key.
+func requireCSRFToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isAPIRequest(r) {
+			next(w, r)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cookie, err := r.Cookie(csrfCookie)
+		token := r.Header.Get(csrfHeader)
+		if token == "" {
+			token = r.PostForm.Get(csrfField)
+		}
+		if err != nil || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) != 1 {
+			deny(w, r, errCSRF)
+			return
+		}
+
+		// The token is for the launcher rather than a claim
+		r.PostForm.Del(csrfField)
+		r.Form.Del(csrfField)
+		next(w, r)
+	}
+}
+
+// errAPIKeyRequired is returned for a GET which makes tokens without the API key
+var errAPIKeyRequired = &accessError{StatusCode: http.StatusForbidden, Desc: "Only API_KEY clients can GET this; POST it with a CSRF token instead"}
+
+// requireAPIKey only passes on requests authenticated with API_KEY, for GETs which make tokens, as a link on
+// another site could make them through a tester's browser
+func requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAPIRequest(r) {
+			deny(w, r, errAPIKeyRequired)
+			return
+		}
+		next(w, r)
+	}
+}
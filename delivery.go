@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+)
+
+// tokenDeliveryPost is the RUNNER_TOKEN_DELIVERY which has the browser POST tokens to runner in a form, so
+// they aren't in URLs, browser history or runner's access logs
+const tokenDeliveryPost = "post"
+
+// runnerRequest is how the browser is sent to one of runner's endpoints with a token
+type runnerRequest struct {
+	// URL is runner's endpoint, with the token in its query unless Token is set to be POSTed in the form
+	URL   string
+	Token string
+
+	// Post is set when the browser is given a form to POST to runner rather than being redirected
+	Post bool
+}
+
+// newRunnerRequest builds the request taking the token to runner's endpoint in the target
+func newRunnerRequest(t *target, endpoint string, token string) (runnerRequest, error) {
+	runnerURL := t.config.Get("SURVEY_RUNNER_URL")
+
+	if t.config.Get("RUNNER_TOKEN_DELIVERY") == tokenDeliveryPost {
+		endpointURL, err := authentication.RunnerURL(runnerURL, endpoint, "")
+		return runnerRequest{URL: endpointURL, Token: token, Post: true}, err
+	}
+
+	// Runner only accepts flushes as POSTs, which a redirect can't make without re-POSTing the launcher's form
+	endpointURL, err := authentication.RunnerURL(runnerURL, endpoint, token)
+	return runnerRequest{URL: endpointURL, Post: endpoint == authentication.FlushEndpoint}, err
+}
+
+// send takes the browser to runner. Redirects are 303 See Other, so the browser GETs runner's page and
+// doesn't cache the redirect, and forms are POSTed as soon as the page loads.
+func (req runnerRequest) send(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if req.Post {
+		serveTemplate("runner-post.html", req, w, r)
+		return
+	}
+	http.Redirect(w, r, req.URL, http.StatusSeeOther)
+}
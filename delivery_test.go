@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLaunchRedirectsAreNotCached(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.get("/quick-launch?url=" + url.QueryEscape(h.runner.URL+"/schemas/1/0205"))
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("Expected an uncached 303 but recieved %d with Cache-Control %q", resp.StatusCode, resp.Header.Get("Cache-Control"))
+	}
+}
+
+func TestPostTokenDeliveryKeepsTokensOutOfURLs(t *testing.T) {
+	h := newHarnessWithSettings(t, map[string]string{"RUNNER_TOKEN_DELIVERY": "post"})
+	defer h.Close()
+
+	resp := h.postForm("/", url.Values{
+		"schema":           {"1_0205.json"},
+		"ru_name":          {"ACME"},
+		"ref_p_start_date": {"2020-01-01"},
+		"action_launch":    {"Open Survey"},
+	})
+	defer resp.Body.Close()
+
+	claims := h.claims(h.tokenFromForm(resp, "/session", true))
+	if claims["ru_name"] != "ACME" {
+		t.Errorf("Expected the posted token to be for ACME but recieved %v", claims["ru_name"])
+	}
+
+	resp = h.get("/quick-launch?url=" + url.QueryEscape(h.runner.URL+"/schemas/1/0205"))
+	defer resp.Body.Close()
+	if location := resp.Header.Get("Location"); strings.Contains(location, "token=") {
+		t.Errorf("Expected quick-launch not to redirect with the token but recieved %s", location)
+	}
+	h.claims(h.tokenFromForm(resp, "/session", true))
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// maxFlushItems limits the number of responses a single flush request can flush
+const maxFlushItems = 1000
+
+// flushItemsHeader names the columns of the items field of the flush form
+var flushItemsHeader = []string{"schema", "case_id", "response_id", "ru_ref"}
+
+// flushRequest lists the responses to flush, given directly or as launches in the history. Items are
+// flushed in the target; launches in the target they were launched into.
+type flushRequest struct {
+	Target   string                     `json:"target"`
+	Items    []authentication.FlushItem `json:"items"`
+	Launches []int                      `json:"launches"`
+}
+
+// flushResult reports the flush of one response
+type flushResult struct {
+	authentication.FlushItem
+
+	// Launch is the launch in the history which was flushed, if the response was picked from it
+	Launch int    `json:"launch,omitempty"`
+	Target string `json:"target,omitempty"`
+	TxID   string `json:"tx_id,omitempty"`
+
+	// StatusCode is runner's response to the flush, or 0 if runner wasn't reached
+	StatusCode int    `json:"status_code,omitempty"`
+	Flushed    bool   `json:"flushed"`
+	Error      string `json:"error,omitempty"`
+}
+
+// isJSONRequest checks whether the request body is JSON
+func isJSONRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+}
+
+// parseFlushRequest reads a flushRequest from a JSON body, or from the flush form. The form's items field
+// has a line of schema,case_id,response_id,ru_ref for each response, where the schema is a name or URL.
+func parseFlushRequest(r *http.Request) (flushRequest, error) {
+	var req flushRequest
+	if isJSONRequest(r) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, fmt.Errorf("Failed to read flush request: %w", err)
+		}
+		if req.Target == "" {
+			req.Target = r.URL.Query().Get(targetField)
+		}
+		return req, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return req, err
+	}
+	req.Target = r.FormValue(targetField)
+
+	for _, value := range r.Form["launch"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return req, fmt.Errorf("Invalid launch %q", value)
+		}
+		req.Launches = append(req.Launches, id)
+	}
+
+	reader := csv.NewReader(strings.NewReader(r.FormValue("items")))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return req, fmt.Errorf("Invalid items: %w", err)
+		}
+		if len(fields) > len(flushItemsHeader) {
+			return req, fmt.Errorf("Invalid items: expected %s but got %q", strings.Join(flushItemsHeader, ","), strings.Join(fields, ","))
+		}
+		fields = append(fields, make([]string, len(flushItemsHeader)-len(fields))...)
+		if fields[0] == flushItemsHeader[0] {
+			continue
+		}
+
+		item := authentication.FlushItem{CaseID: fields[1], ResponseID: fields[2], RURef: fields[3]}
+		if strings.HasPrefix(fields[0], "http://") || strings.HasPrefix(fields[0], "https://") {
+			item.URL = fields[0]
+		} else {
+			item.Schema = fields[0]
+		}
+		req.Items = append(req.Items, item)
+	}
+
+	return req, nil
+}
+
+type flushPage struct {
+	Targets []string
+	Target  string
+	Items   string
+	Results []flushResult
+	Error   string
+}
+
+// getFlushHandler shows the form for flushing responses
+func (l *launcher) getFlushHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := l.target(r)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+	serveTemplate("flush.html", flushPage{Targets: l.targetNames(), Target: t.name}, w, r)
+}
+
+// postFlushHandler flushes each of the responses in the request through runner, reporting the result of each
+func (l *launcher) postFlushHandler(w http.ResponseWriter, r *http.Request) {
+	asJSON := wantsJSON(r) || isJSONRequest(r)
+	req, err := parseFlushRequest(r)
+	p := flushPage{Targets: l.targetNames(), Target: req.Target, Items: r.PostFormValue("items")}
+	if err == nil && len(req.Items)+len(req.Launches) == 0 {
+		err = fmt.Errorf("Nothing to flush; give items or launches")
+	}
+	if err == nil && len(req.Items)+len(req.Launches) > maxFlushItems {
+		err = fmt.Errorf("Too many to flush; at most %d can be flushed at once", maxFlushItems)
+	}
+	if err != nil {
+		if asJSON {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		p.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		serveTemplate("flush.html", p, w, r)
+		return
+	}
+
+	t, err := l.targetNamed(req.Target)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+	p.Target = t.name
+
+	results := make([]flushResult, 0, len(req.Items)+len(req.Launches))
+	for _, item := range req.Items {
+		results = append(results, l.flushItem(r, t, item))
+	}
+	for _, id := range req.Launches {
+		results = append(results, l.flushLaunch(r, id))
+	}
+
+	if asJSON {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+		return
+	}
+	p.Results = results
+	serveTemplate("flush.html", p, w, r)
+}
+
+// flushItem flushes the response identified by the item in the target
+func (l *launcher) flushItem(r *http.Request, t *target, item authentication.FlushItem) flushResult {
+	result := flushResult{FlushItem: item, Target: t.name}
+
+	claims, err := t.authentication.FlushClaims(item)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	schema := item.Schema
+	if schema == "" {
+		schema = item.URL
+	}
+	l.flush(r, t, schema, claims, &result)
+	return result
+}
+
+// flushLaunch flushes the response of a launch in the history, with the same claims it was launched with
+func (l *launcher) flushLaunch(r *http.Request, id int) flushResult {
+	result := flushResult{Launch: id}
+
+	record, ok := l.history.get(id)
+	if !ok {
+		result.Error = fmt.Sprintf("Launch %d isn't in the launch history", id)
+		return result
+	}
+	result.Schema = record.Schema
+	result.Target = record.Target
+
+	t, err := l.targetNamed(record.Target)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	l.flush(r, t, record.Schema, authentication.FlushClaimsFromLaunch(record.claims), &result)
+	return result
+}
+
+// flush calls runner's flush endpoint with the claims, if the policy allows the request's user to flush, recording
+// the flush in the launch history as made by them
+func (l *launcher) flush(r *http.Request, t *target, schema string, claims map[string]interface{}, result *flushResult) {
+	result.TxID = authentication.ClaimString(claims, "tx_id")
+	result.CaseID = authentication.ClaimString(claims, "case_id")
+	result.ResponseID = authentication.ClaimString(claims, "response_id")
+	result.RURef = authentication.ClaimString(claims, "ru_ref")
+
+	if err := t.authentication.Authorize(requestPrincipal(r), authentication.ActionFlush, claims); err != nil {
+		result.Error = err.Error()
+		return
+	}
+
+	statusCode, err := t.authentication.Flush(claims)
+	result.StatusCode = statusCode
+	if statusCode != 0 {
+		l.recordLaunch(r, newLaunchRecord(t.name, "flush", schema, claims))
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	result.Flushed = true
+}
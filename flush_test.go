@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// postFlush posts the JSON flush request and returns the results
+func (h *harness) postFlush(request string) []flushResult {
+	resp, err := h.client.Do(newJSONRequest(h.launcher.URL, "/flush", request))
+	if err != nil {
+		h.t.Fatalf("POST /flush failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		h.t.Fatalf("Expected status 200 but recieved %d: %s", resp.StatusCode, body)
+	}
+	var response struct {
+		Results []flushResult `json:"results"`
+	}
+	json.NewDecoder(resp.Body).Decode(&response)
+	return response.Results
+}
+
+func TestFlushItemsSendsFlusherTokensToRunner(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	results := h.postFlush(`{"items": [
+		{"schema": "1_0205.json", "ru_ref": "12345678901A", "case_id": "case-1"},
+		{"schema": "missing.json"}
+	]}`)
+
+	if len(results) != 2 {
+		t.Fatalf("Expected a result for each item but recieved %+v", results)
+	}
+	if !results[0].Flushed || results[0].StatusCode != http.StatusOK || results[0].TxID == "" {
+		t.Errorf("Expected the first item to be flushed but recieved %+v", results[0])
+	}
+	if results[1].Flushed || results[1].Error == "" {
+		t.Errorf("Expected an error for the missing schema but recieved %+v", results[1])
+	}
+
+	flushes := h.runnerFlushes()
+	if len(flushes) != 1 {
+		t.Fatalf("Expected runner to receive 1 flush but recieved %d", len(flushes))
+	}
+	if flushes[0]["ru_ref"] != "12345678901A" || flushes[0]["case_id"] != "case-1" || flushes[0]["eq_id"] != "1" {
+		t.Errorf("Expected the item's identifiers in the flush token but recieved %v", flushes[0])
+	}
+	if roles, _ := flushes[0]["roles"].([]interface{}); len(roles) != 1 || roles[0] != "flusher" {
+		t.Errorf("Expected the flusher role but recieved %v", flushes[0]["roles"])
+	}
+	if n := h.runnerQueryTokens(); n != 0 {
+		t.Errorf("Expected the flush token to be POSTed in the form but recieved %d in the URL", n)
+	}
+}
+
+func TestFlushLaunchesFromHistory(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	launched := h.launchForm()
+
+	resp := h.postForm("/flush", url.Values{"launch": {"1", "99"}})
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "Flushed") || !strings.Contains(string(body), "Launch 99 isn&#39;t in the launch history") {
+		t.Errorf("Expected the flush results page but recieved %d: %s", resp.StatusCode, body)
+	}
+
+	flushes := h.runnerFlushes()
+	if len(flushes) != 1 {
+		t.Fatalf("Expected runner to receive 1 flush but recieved %d", len(flushes))
+	}
+	for _, name := range []string{"ru_ref", "ru_name", "user_id", "collection_exercise_sid"} {
+		if flushes[0][name] != launched[name] {
+			t.Errorf("Expected the flush to have the launch's %s %v but recieved %v", name, launched[name], flushes[0][name])
+		}
+	}
+	if flushes[0]["tx_id"] == launched["tx_id"] {
+		t.Errorf("Expected the flush to have a new tx_id")
+	}
+
+	var launches []historyEntry
+	h.getJSON("/history", &launches)
+	if len(launches) != 2 || launches[0].Action != "flush" {
+		t.Errorf("Expected the flush to be recorded in the history but recieved %+v", launches)
+	}
+}
+
+func TestFlushFormItems(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.postForm("/flush", url.Values{"items": {"schema,case_id,response_id,ru_ref\n1_0205.json,,resp-1\n\n1_0205.json,case-2"}})
+	resp.Body.Close()
+
+	flushes := h.runnerFlushes()
+	if resp.StatusCode != http.StatusOK || len(flushes) != 2 {
+		t.Fatalf("Expected 2 flushes but recieved %d from status %d", len(flushes), resp.StatusCode)
+	}
+	if flushes[0]["response_id"] != "resp-1" || flushes[1]["case_id"] != "case-2" {
+		t.Errorf("Expected the items' identifiers but recieved %v and %v", flushes[0], flushes[1])
+	}
+
+	resp = h.postForm("/flush", url.Values{"items": {"1_0205.json,a,b,c,d"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an item with too many fields but recieved %d", resp.StatusCode)
+	}
+
+	resp = h.postForm("/flush", url.Values{})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 with nothing to flush but recieved %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,286 @@
+package generators
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkLetters are the letters used for ru_ref check letters; I, O and U are excluded
+// as they are too easily confused with digits
+const checkLetters = "ABCDEFGHJKLMNPQRSTVWXYZ"
+
+var checkLetterWeights = []int{8, 4, 3, 2, 6, 7, 8, 4, 3, 2}
+
+// CheckLetter returns the check letter for an 11 digit reporting unit reference. The
+// weighted sum of the last ten digits, modulo 23, indexes the check letter alphabet.
+func CheckLetter(ruRef string) (string, error) {
+	if len(ruRef) != 11 {
+		return "", fmt.Errorf("ru_ref must be 11 digits, got %q", ruRef)
+	}
+
+	sum := 0
+	for i, c := range ruRef[1:] {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("ru_ref must be 11 digits, got %q", ruRef)
+		}
+		sum += int(c-'0') * checkLetterWeights[i]
+	}
+
+	return string(checkLetters[sum%len(checkLetters)]), nil
+}
+
+// Generator produces realistic fake values for survey metadata
+type Generator struct {
+	rand *rand.Rand
+	now  time.Time
+	Seed int64
+
+	// PeriodIDFormat and PeriodStrFormat are the time layouts used for period_id and period_str
+	PeriodIDFormat  string
+	PeriodStrFormat string
+
+	// RURefMin and RURefMax bound the 11 digit part of generated ru_refs
+	RURefMin int64
+	RURefMax int64
+}
+
+// seedEpoch is the time the dates of seeds which aren't a time, such as 42, are relative to
+var seedEpoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// referenceTime returns the time the seed's dates are relative to, so a seed gives the same dates whenever
+// it is used. The seeds New picks are the time they were picked, so their dates are recent.
+func referenceTime(seed int64) time.Time {
+	if t := time.Unix(0, seed).UTC(); t.After(seedEpoch) {
+		return t
+	}
+	return seedEpoch
+}
+
+// New creates a Generator from the seed, so the same seed always produces the same values, including
+// dates. A seed of 0 picks a random seed.
+func New(seed int64) *Generator {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Generator{
+		rand:            rand.New(rand.NewSource(seed)),
+		now:             referenceTime(seed),
+		Seed:            seed,
+		PeriodIDFormat:  "200601",
+		PeriodStrFormat: "January 2006",
+		RURefMin:        49900000000,
+		RURefMax:        49999999999,
+	}
+}
+
+// ParseSeed reads a seed from a string, returning 0 (a random seed) if it is empty or invalid
+func ParseSeed(value string) int64 {
+	seed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seed
+}
+
+// Digits returns a string of n random digits
+func (g *Generator) Digits(n int) string {
+	output := make([]byte, n)
+	for i := range output {
+		output[i] = byte('0' + g.rand.Intn(10))
+	}
+	return string(output)
+}
+
+// UUID returns a random version 4 UUID
+func (g *Generator) UUID() string {
+	b := make([]byte, 16)
+	g.rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// RURef returns a reporting unit reference between RURefMin and RURefMax with a valid check letter
+func (g *Generator) RURef() string {
+	ruRef := fmt.Sprintf("%011d", g.RURefMin+g.rand.Int63n(g.RURefMax-g.RURefMin+1))
+	letter, _ := CheckLetter(ruRef)
+	return ruRef + letter
+}
+
+// SequentialRURef returns the ru_ref offset places after start, wrapping around within RURefMin and
+// RURefMax, so a run of references is distinct for as long as the range allows. A start outside the
+// range, or a negative offset, wraps into it too.
+func (g *Generator) SequentialRURef(start int64, offset int64) string {
+	size := g.RURefMax - g.RURefMin + 1
+	position := ((start-g.RURefMin)%size + size) % size
+	position = (position + (offset%size+size)%size) % size
+	ruRef := fmt.Sprintf("%011d", g.RURefMin+position)
+	letter, _ := CheckLetter(ruRef)
+	return ruRef + letter
+}
+
+// Period is a monthly collection period
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ID returns the period in the YYYYMM period_id format
+func (p Period) ID() string {
+	return p.Start.Format("200601")
+}
+
+// String returns the period in the "January 2006" period_str format
+func (p Period) String() string {
+	return p.Start.Format("January 2006")
+}
+
+// Period returns one of the twelve complete months before the seed's reference time
+func (g *Generator) Period() Period {
+	thisMonth := time.Date(g.now.Year(), g.now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	start := thisMonth.AddDate(0, -1-g.rand.Intn(12), 0)
+	return Period{
+		Start: start,
+		End:   start.AddDate(0, 1, -1),
+	}
+}
+
+// Address is a UK postal address
+type Address struct {
+	Line1      string
+	Line2      string
+	Locality   string
+	TownName   string
+	Postcode   string
+	RegionCode string
+	Country    string
+}
+
+// Display returns the address in the short display_address format
+func (a Address) Display() string {
+	return a.Line1 + ", " + a.TownName
+}
+
+type town struct {
+	name       string
+	area       string
+	regionCode string
+	country    string
+}
+
+var towns = []town{
+	{"Newport", "NP", "GB-WLS", "W"},
+	{"Cardiff", "CF", "GB-WLS", "W"},
+	{"Fareham", "PO", "GB-ENG", "E"},
+	{"Titchfield", "PO", "GB-ENG", "E"},
+	{"Southampton", "SO", "GB-ENG", "E"},
+	{"Exeter", "EX", "GB-ENG", "E"},
+	{"Norwich", "NR", "GB-ENG", "E"},
+	{"York", "YO", "GB-ENG", "E"},
+	{"Leeds", "LS", "GB-ENG", "E"},
+	{"Manchester", "M", "GB-ENG", "E"},
+	{"Darlington", "DL", "GB-ENG", "E"},
+	{"Peterborough", "PE", "GB-ENG", "E"},
+	{"Edinburgh", "EH", "GB-SCT", "S"},
+	{"Glasgow", "G", "GB-SCT", "S"},
+	{"Belfast", "BT", "GB-NIR", "N"},
+}
+
+var streetNames = []string{
+	"Abingdon", "Cardiff", "Church", "Mill", "Station", "Victoria", "Park", "Queens",
+	"Kings", "Orchard", "Meadow", "Chapel", "Castle", "Bridge", "Manor", "Willow",
+}
+
+var streetTypes = []string{"Road", "Street", "Lane", "Avenue", "Close", "Drive", "Way", "Crescent"}
+
+var localities = []string{"", "", "Old Town", "Westfield", "Northgate", "Riverside"}
+
+// postcodeLetters are the letters allowed in the inward code of a UK postcode
+const postcodeLetters = "ABDEFGHJLNPQRSTUWXYZ"
+
+// Postcode returns a correctly formatted postcode for the postcode area
+func (g *Generator) Postcode(area string) string {
+	return fmt.Sprintf("%s%d %d%c%c",
+		area,
+		1+g.rand.Intn(20),
+		g.rand.Intn(10),
+		postcodeLetters[g.rand.Intn(len(postcodeLetters))],
+		postcodeLetters[g.rand.Intn(len(postcodeLetters))])
+}
+
+// Address returns a plausible UK address
+func (g *Generator) Address() Address {
+	t := towns[g.rand.Intn(len(towns))]
+	return Address{
+		Line1:      fmt.Sprintf("%d %s %s", 1+g.rand.Intn(150), g.pick(streetNames), g.pick(streetTypes)),
+		Line2:      "",
+		Locality:   g.pick(localities),
+		TownName:   t.name,
+		Postcode:   g.Postcode(t.area),
+		RegionCode: t.regionCode,
+		Country:    t.country,
+	}
+}
+
+var tradingAdjectives = []string{
+	"ESSENTIAL", "PREMIER", "ROYAL", "NATIONAL", "GLOBAL", "UNITED", "CAPITAL", "NORTHERN",
+	"SOUTHERN", "WESTERN", "COASTAL", "VALLEY", "HIGHLAND", "METRO", "CROWN", "STERLING",
+}
+
+var tradingNouns = []string{
+	"ENTERPRISE", "ENGINEERING", "FOODS", "LOGISTICS", "TEXTILES", "BUILDERS", "MOTORS",
+	"TRADING", "SUPPLIES", "HOLDINGS", "SERVICES", "BAKERIES", "PRINTERS", "SYSTEMS",
+}
+
+var tradingSuffixes = []string{"LTD.", "LIMITED", "PLC", "& SONS LTD.", "GROUP LTD."}
+
+// TradingName returns a random business name
+func (g *Generator) TradingName() string {
+	return strings.Join([]string{g.pick(tradingAdjectives), g.pick(tradingNouns), g.pick(tradingSuffixes)}, " ")
+}
+
+func (g *Generator) pick(values []string) string {
+	return values[g.rand.Intn(len(values))]
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// Values returns a consistent set of values for the known metadata names
+func (g *Generator) Values() map[string]string {
+	values := make(map[string]string)
+
+	period := g.Period()
+	address := g.Address()
+	ruName := g.TradingName()
+
+	values["user_id"] = "UNKNOWN"
+	values["period_id"] = period.Start.Format(g.PeriodIDFormat)
+	values["period_str"] = period.Start.Format(g.PeriodStrFormat)
+	values["collection_exercise_sid"] = g.UUID()
+	values["case_id"] = g.UUID()
+	values["response_id"] = g.Digits(16)
+	values["ru_ref"] = g.RURef()
+	values["ru_name"] = ruName
+	values["trad_as"] = ruName
+	values["ref_p_start_date"] = formatDate(period.Start)
+	values["ref_p_end_date"] = formatDate(period.End)
+	values["return_by"] = formatDate(period.End.AddDate(0, 0, 7+g.rand.Intn(21)))
+	values["employment_date"] = formatDate(period.Start.AddDate(0, 0, g.rand.Intn(period.End.Day())))
+	values["region_code"] = address.RegionCode
+	values["language_code"] = "en"
+	values["case_ref"] = "1" + g.Digits(15)
+	values["address_line1"] = address.Line1
+	values["address_line2"] = address.Line2
+	values["locality"] = address.Locality
+	values["town_name"] = address.TownName
+	values["postcode"] = address.Postcode
+	values["display_address"] = address.Display()
+	values["country"] = address.Country
+
+	return values
+}
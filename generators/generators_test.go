@@ -0,0 +1,113 @@
+package generators
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestCheckLetter(t *testing.T) {
+	letter, err := CheckLetter("49900000001")
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if letter != "V" {
+		t.Errorf("Expected check letter V but recieved %s", letter)
+	}
+
+	if _, err := CheckLetter("4990000001A"); err == nil {
+		t.Errorf("Expected an error for a non numeric ru_ref")
+	}
+}
+
+func TestGeneratorIsReproducibleFromSeed(t *testing.T) {
+	first := New(42).Values()
+	second := New(42).Values()
+
+	for key, value := range first {
+		if second[key] != value {
+			t.Errorf("Expected %s to be %s for the same seed but recieved %s", key, value, second[key])
+		}
+	}
+}
+
+func TestGeneratorDatesFollowTheSeed(t *testing.T) {
+	// A seed picked by New is the time it was picked, so its periods are the months before
+	picked := time.Date(2026, time.March, 15, 10, 0, 0, 0, time.UTC).UnixNano()
+	for _, period := range []Period{New(picked).Period(), New(picked).Period()} {
+		if period.Start.Before(time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)) || !period.Start.Before(time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected a period in the year before March 2026 but recieved %s", period.ID())
+		}
+	}
+
+	// Other seeds give the same dates whenever they are used
+	if period := New(42).Period(); period.Start.Year() != 2019 {
+		t.Errorf("Expected seed 42 to give a period in 2019 but recieved %s", period.ID())
+	}
+}
+
+func TestGeneratorValuesAreConsistent(t *testing.T) {
+	values := New(7).Values()
+
+	start, _ := time.Parse("2006-01-02", values["ref_p_start_date"])
+	end, _ := time.Parse("2006-01-02", values["ref_p_end_date"])
+	returnBy, _ := time.Parse("2006-01-02", values["return_by"])
+
+	if start.Format("200601") != values["period_id"] {
+		t.Errorf("Expected ref_p_start_date %s to be in period %s", values["ref_p_start_date"], values["period_id"])
+	}
+	if end.AddDate(0, 0, 1).Day() != 1 {
+		t.Errorf("Expected ref_p_end_date %s to be the last day of the month", values["ref_p_end_date"])
+	}
+	if !returnBy.After(end) {
+		t.Errorf("Expected return_by %s to be after %s", values["return_by"], values["ref_p_end_date"])
+	}
+	if !regexp.MustCompile(`^[A-Z]{1,2}[0-9]{1,2} [0-9][A-Z]{2}$`).MatchString(values["postcode"]) {
+		t.Errorf("Expected a valid postcode but recieved %s", values["postcode"])
+	}
+
+	letter, _ := CheckLetter(values["ru_ref"][:11])
+	if values["ru_ref"][11:] != letter {
+		t.Errorf("Expected ru_ref %s to end with check letter %s", values["ru_ref"], letter)
+	}
+}
+
+func TestSequentialRURefWrapsWithinRange(t *testing.T) {
+	generator := New(1)
+	generator.RURefMin, generator.RURefMax = 49900000001, 49900000003
+
+	var ruRefs []string
+	for i := int64(0); i < 4; i++ {
+		ruRefs = append(ruRefs, generator.SequentialRURef(49900000002, i)[:11])
+	}
+
+	expected := []string{"49900000002", "49900000003", "49900000001", "49900000002"}
+	for i := range expected {
+		if ruRefs[i] != expected[i] {
+			t.Errorf("Expected %v but recieved %v", expected, ruRefs)
+			break
+		}
+	}
+}
+
+func TestSequentialRURefStaysWithinRange(t *testing.T) {
+	generator := New(1)
+	generator.RURefMin, generator.RURefMax = 49900000001, 49900000003
+
+	for _, test := range []struct {
+		start    int64
+		offset   int64
+		expected string
+	}{
+		{49900000002, -1, "49900000001"},
+		{49900000002, -2, "49900000003"},
+		{49900000001, -7, "49900000003"},
+		{0, -1, "49900000001"},
+		{0, -2, "49900000003"},
+		{-5, 0, "49900000003"},
+	} {
+		if ruRef := generator.SequentialRURef(test.start, test.offset)[:11]; ruRef != test.expected {
+			t.Errorf("Expected %s for start %d and offset %d but recieved %s", test.expected, test.start, test.offset, ruRef)
+		}
+	}
+}
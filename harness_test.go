@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+	"github.com/ONSdigital/go-launch-a-survey/settings"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+const launcherSigningKeyPath = "jwt-test-keys/sdc-user-authentication-signing-launcher-private-key.pem"
+
+// runnerSchemas are the schemas served by the fake runner, keyed by eq_id/form_type
+var runnerSchemas = map[string]string{
+	"1/0205": `{
+		"eq_id": "1",
+		"form_type": "0205",
+		"title": "Monthly Business Survey",
+		"metadata": [
+			{"name": "ru_name", "validator": "string"},
+			{"name": "ref_p_start_date", "validator": "date"},
+			{"name": "trad_as", "validator": "string", "optional": true}
+		]
+	}`,
+	"invalid/0001": `{"eq_id": "invalid", "form_type": "0001", "metadata": []}`,
+}
+
+// registerSchema is the schema served by the fake register for every version
+const registerSchema = `{
+	"eq_id": "ecommerce",
+	"form_type": "002",
+	"title": "Ecommerce",
+	"metadata": [
+		{"name": "ru_name", "validator": "string"},
+		{"name": "employment_date", "validator": "date"}
+	]
+}`
+
+var (
+	runnerKeyOnce sync.Once
+	runnerKey     *rsa.PrivateKey
+	runnerKeyErr  error
+)
+
+// testRunnerKey is runner's private key, which decrypts the tokens. The repository only has runner's
+// public key, so a key pair is generated once for the tests.
+func testRunnerKey(t *testing.T) *rsa.PrivateKey {
+	runnerKeyOnce.Do(func() {
+		runnerKey, runnerKeyErr = rsa.GenerateKey(rand.Reader, 2048)
+	})
+	if runnerKeyErr != nil {
+		t.Fatalf("Failed to generate runner key: %s", runnerKeyErr)
+	}
+	return runnerKey
+}
+
+// harness runs the launcher's router against fake runner, register and schema validator servers
+type harness struct {
+	t *testing.T
+
+	launcher  *httptest.Server
+	runner    *httptest.Server
+	register  *httptest.Server
+	validator *httptest.Server
+
+	// client doesn't follow redirects, so the launcher's responses can be checked
+	client *http.Client
+
+	encryptionKeyPath string
+	closeLauncher     func()
+
+	mutex    sync.Mutex
+	sessions []map[string]interface{}
+	flushes  []map[string]interface{}
+
+	// queryTokens counts the tokens runner was sent in URLs rather than POSTed form bodies
+	queryTokens int
+}
+
+func newHarness(t *testing.T) *harness {
+	return newHarnessWithSettings(t, nil)
+}
+
+// newHarnessWithSettings starts the fakes and a launcher using them, with the values overriding its settings
+func newHarnessWithSettings(t *testing.T, values map[string]string) *harness {
+	h := &harness{t: t}
+	h.runner = httptest.NewServer(http.HandlerFunc(h.serveRunner))
+	h.register = httptest.NewServer(http.HandlerFunc(h.serveRegister))
+	h.validator = httptest.NewServer(http.HandlerFunc(h.serveValidator))
+	h.client = &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&testRunnerKey(t).PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal runner key: %s", err)
+	}
+	keyFile, err := ioutil.TempFile("", "runner-public-key-*.pem")
+	if err != nil {
+		t.Fatalf("Failed to create runner key file: %s", err)
+	}
+	pem.Encode(keyFile, &pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})
+	keyFile.Close()
+	h.encryptionKeyPath = keyFile.Name()
+
+	config := map[string]string{
+		"SURVEY_RUNNER_URL":        h.runner.URL,
+		"SURVEY_RUNNER_SCHEMA_URL": h.runner.URL,
+		"SURVEY_REGISTER_URL":      h.register.URL,
+		"SCHEMA_VALIDATOR_URL":     h.validator.URL,
+		"JWT_ENCRYPTION_KEY_PATH":  h.encryptionKeyPath,
+		"JWT_SIGNING_KEY_PATH":     launcherSigningKeyPath,
+		"SUBMISSION_SINK_TOKEN":    testSubmissionToken,
+	}
+	for name, value := range values {
+		config[name] = value
+	}
+
+	l, err := newServerLauncher(settings.FromValues(config))
+	if err != nil {
+		h.Close()
+		t.Fatalf("Failed to create launcher: %s", err)
+	}
+	h.closeLauncher = l.Close
+	h.launcher = httptest.NewServer(l.handler())
+
+	return h
+}
+
+// Close stops the servers and removes the runner key file
+func (h *harness) Close() {
+	for _, server := range []*httptest.Server{h.launcher, h.runner, h.register, h.validator} {
+		if server != nil {
+			server.Close()
+		}
+	}
+	if h.encryptionKeyPath != "" {
+		os.Remove(h.encryptionKeyPath)
+	}
+	if h.closeLauncher != nil {
+		h.closeLauncher()
+	}
+}
+
+func (h *harness) serveRunner(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/schemas":
+		w.Write([]byte(`["1_0205.json", "test_checkbox.json"]`))
+	case strings.HasPrefix(r.URL.Path, "/schemas/"):
+		schema, ok := runnerSchemas[strings.TrimPrefix(r.URL.Path, "/schemas/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(schema))
+	case r.URL.Path == "/session" || r.URL.Path == "/flush":
+		if r.URL.Query().Get("token") != "" {
+			h.mutex.Lock()
+			h.queryTokens++
+			h.mutex.Unlock()
+		}
+		claims, err := h.decryptToken(r.FormValue("token"))
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("<html><head><title>Error</title></head><body><h1>Sorry, there is a problem</h1><p>" + html.EscapeString(err.Error()) + "</p></body></html>"))
+			return
+		}
+		h.mutex.Lock()
+		if r.URL.Path == "/session" {
+			h.sessions = append(h.sessions, claims)
+		} else {
+			h.flushes = append(h.flushes, claims)
+		}
+		h.mutex.Unlock()
+
+		// Like runner, a session starts with a cookie and a redirect to the questionnaire
+		if r.URL.Path == "/session" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: claims["tx_id"].(string), Path: "/"})
+			http.Redirect(w, r, fmt.Sprintf("/questionnaire/%s/%s/", claims["eq_id"], claims["form_type"]), http.StatusFound)
+			return
+		}
+		w.Write([]byte("OK"))
+	case strings.HasPrefix(r.URL.Path, "/questionnaire/"):
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value == "" {
+			http.Error(w, "Session timed out", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("Questionnaire"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *harness) serveRegister(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/questionnaires/published":
+		w.Write([]byte(`[{
+			"registry_id": "b02f1331-57f3-4427-8182-c969dbed6414",
+			"survey_id": "187",
+			"form_type": "002",
+			"title": "Ecommerce",
+			"lastPublished": "2019-12-12T08:55:27.731Z",
+			"survey_version": "2",
+			"eq_id": "ecommerce"
+		}]`))
+	case "/questionnaires/versions":
+		w.Write([]byte(`[
+			{"registry_id": "a1", "survey_version": "1", "lastPublished": "2019-11-01T10:00:00.000Z"},
+			{"registry_id": "b02f1331-57f3-4427-8182-c969dbed6414", "survey_version": "2", "lastPublished": "2019-12-12T08:55:27.731Z"}
+		]`))
+	case "/questionnaires/version":
+		w.Write([]byte(registerSchema))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveValidator reports any schema with an eq_id of "invalid" as invalid
+func (h *harness) serveValidator(w http.ResponseWriter, r *http.Request) {
+	var schema struct {
+		EqID string `json:"eq_id"`
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(body, &schema)
+
+	if schema.EqID == "invalid" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errors": [{"message": "'invalid' is not a valid eq_id", "pointer": "/eq_id"}]}`))
+		return
+	}
+	w.Write([]byte(`{}`))
+}
+
+// decryptToken decrypts the token with runner's key and checks it was signed by the launcher
+func (h *harness) decryptToken(token string) (map[string]interface{}, error) {
+	signing := authentication.NewService(settings.FromValues(map[string]string{"JWT_SIGNING_KEY_PATH": launcherSigningKeyPath}), nil, nil)
+	kid, verificationKey, err := signing.VerificationKey()
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := authentication.DecryptToken(token, testRunnerKey(h.t), map[string]*rsa.PublicKey{kid: verificationKey})
+	if err != nil {
+		return nil, err
+	}
+	return decrypted.Claims, nil
+}
+
+// claims decrypts the token, failing the test if it can't
+func (h *harness) claims(token string) map[string]interface{} {
+	claims, err := h.decryptToken(token)
+	if err != nil {
+		h.t.Fatalf("Failed to decrypt token: %s", err)
+	}
+	return claims
+}
+
+// tokenFromRedirect returns the token from a redirect to runner's endpoint, failing the test for any other response
+func (h *harness) tokenFromRedirect(resp *http.Response, endpoint string) string {
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, h.runner.URL+endpoint+"?token=") {
+		body, _ := ioutil.ReadAll(resp.Body)
+		h.t.Fatalf("Expected a redirect to runner's %s but recieved %d %q: %s", endpoint, resp.StatusCode, location, body)
+	}
+	return strings.TrimPrefix(location, h.runner.URL+endpoint+"?token=")
+}
+
+var (
+	formAction = regexp.MustCompile(`<form id="runner-form" action="([^"]*)" method="post">`)
+	formToken  = regexp.MustCompile(`<input type="hidden" name="token" value="([^"]*)"`)
+)
+
+// tokenFromForm returns the token from a page POSTing it to runner's endpoint, failing the test for any other
+// response. The token is in the form when posted is set, and otherwise in the form's action.
+func (h *harness) tokenFromForm(resp *http.Response, endpoint string, posted bool) string {
+	body, _ := ioutil.ReadAll(resp.Body)
+	action := formAction.FindSubmatch(body)
+	if resp.StatusCode != http.StatusOK || action == nil {
+		h.t.Fatalf("Expected a form posting to runner's %s but recieved %d: %s", endpoint, resp.StatusCode, body)
+	}
+
+	actionURL := html.UnescapeString(string(action[1]))
+	if !posted {
+		if !strings.HasPrefix(actionURL, h.runner.URL+endpoint+"?token=") {
+			h.t.Fatalf("Expected the form to post the token in runner's %s URL but recieved %s", endpoint, actionURL)
+		}
+		return strings.TrimPrefix(actionURL, h.runner.URL+endpoint+"?token=")
+	}
+
+	token := formToken.FindSubmatch(body)
+	if actionURL != h.runner.URL+endpoint || token == nil {
+		h.t.Fatalf("Expected the form to post a token to runner's %s but recieved %s: %s", endpoint, actionURL, body)
+	}
+	return html.UnescapeString(string(token[1]))
+}
+
+func (h *harness) get(path string) *http.Response {
+	resp, err := h.client.Get(h.launcher.URL + path)
+	if err != nil {
+		h.t.Fatalf("GET %s failed: %s", path, err)
+	}
+	return resp
+}
+
+// testCSRFToken is the CSRF token the tests' forms send back, as a browser would from the launcher's page
+const testCSRFToken = "test-csrf-token"
+
+// newFormRequest makes a form POST to the launcher with a CSRF token, as if from one of the launcher's pages
+func newFormRequest(launcherURL string, path string, values url.Values) *http.Request {
+	form := url.Values{csrfField: {testCSRFToken}}
+	for name, value := range values {
+		form[name] = value
+	}
+
+	req, _ := http.NewRequest("POST", launcherURL+path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: testCSRFToken})
+	return req
+}
+
+// newJSONRequest makes a JSON POST to the launcher with a CSRF token in its header, as if from a script
+// which had loaded one of the launcher's pages
+func newJSONRequest(launcherURL string, path string, body string) *http.Request {
+	req, _ := http.NewRequest("POST", launcherURL+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(csrfHeader, testCSRFToken)
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: testCSRFToken})
+	return req
+}
+
+func (h *harness) postForm(path string, values url.Values) *http.Response {
+	resp, err := h.client.Do(newFormRequest(h.launcher.URL, path, values))
+	if err != nil {
+		h.t.Fatalf("POST %s failed: %s", path, err)
+	}
+	return resp
+}
+
+// runnerSessions returns the claims of each token runner's /session has received
+func (h *harness) runnerSessions() []map[string]interface{} {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]map[string]interface{}(nil), h.sessions...)
+}
+
+// runnerFlushes returns the claims of each token runner's /flush has received
+func (h *harness) runnerFlushes() []map[string]interface{} {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]map[string]interface{}(nil), h.flushes...)
+}
+
+// runnerQueryTokens returns how many tokens runner was sent in URLs, where they'd be logged
+func (h *harness) runnerQueryTokens() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.queryTokens
+}
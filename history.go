@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+)
+
+// launchRecord is a survey launched by the launcher, kept so it can be linked to what runner submits for it
+type launchRecord struct {
+	ID       int       `json:"id"`
+	Time     time.Time `json:"time"`
+	Target   string    `json:"target"`
+	Action   string    `json:"action"`
+	Schema   string    `json:"schema,omitempty"`
+	EqID     string    `json:"eq_id,omitempty"`
+	FormType string    `json:"form_type,omitempty"`
+
+	TxID       string `json:"tx_id,omitempty"`
+	ResponseID string `json:"response_id,omitempty"`
+	CaseID     string `json:"case_id,omitempty"`
+	RURef      string `json:"ru_ref,omitempty"`
+
+	// User is who made the launch, when users are authenticated
+	User string `json:"user,omitempty"`
+
+	// claims are the launch token's, so the same response can be flushed later
+	claims map[string]interface{}
+}
+
+// newLaunchRecord records the claims of a token launching the schema, a name or URL, into the target
+func newLaunchRecord(targetName string, action string, schema string, claims map[string]interface{}) launchRecord {
+	return launchRecord{
+		Target:     targetName,
+		Action:     action,
+		Schema:     schema,
+		EqID:       authentication.ClaimString(claims, "eq_id"),
+		FormType:   authentication.ClaimString(claims, "form_type"),
+		TxID:       authentication.ClaimString(claims, "tx_id"),
+		ResponseID: authentication.ClaimString(claims, "response_id"),
+		CaseID:     authentication.ClaimString(claims, "case_id"),
+		RURef:      authentication.ClaimString(claims, "ru_ref"),
+		claims:     claims,
+	}
+}
+
+// recordLaunch adds the launch to the history as made by the request's user, and logs it
+func (l *launcher) recordLaunch(r *http.Request, record launchRecord) {
+	record.User = requestUser(r)
+	record = l.history.add(record)
+
+	log.Printf("Launch %d: %s of %s into %s%s, tx_id %s", record.ID, record.Action, record.Schema, record.Target, byUser(r), record.TxID)
+}
+
+// launchHistory keeps the most recent launches in memory
+type launchHistory struct {
+	mutex    sync.Mutex
+	size     int
+	lastID   int
+	launches []launchRecord
+}
+
+func newLaunchHistory(size int) *launchHistory {
+	return &launchHistory{size: size}
+}
+
+// add records the launch, dropping the oldest once the history is full, and returns it with its ID set
+func (h *launchHistory) add(record launchRecord) launchRecord {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastID++
+	record.ID = h.lastID
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	if h.size <= 0 {
+		return record
+	}
+
+	h.launches = append(h.launches, record)
+	if len(h.launches) > h.size {
+		h.launches = h.launches[len(h.launches)-h.size:]
+	}
+	return record
+}
+
+// recent returns the launches in the history, newest first
+func (h *launchHistory) recent() []launchRecord {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	launches := make([]launchRecord, len(h.launches))
+	for i, record := range h.launches {
+		launches[len(h.launches)-1-i] = record
+	}
+	return launches
+}
+
+// get returns the launch with the ID, if it is still in the history
+func (h *launchHistory) get(id int) (launchRecord, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, record := range h.launches {
+		if record.ID == id {
+			return record, true
+		}
+	}
+	return launchRecord{}, false
+}
+
+// find returns the newest launch with the tx_id or response_id, which is the launch a submission is for
+func (h *launchHistory) find(txID string, responseID string) (launchRecord, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for i := len(h.launches) - 1; i >= 0; i-- {
+		record := h.launches[i]
+		if (txID != "" && record.TxID == txID) || (responseID != "" && record.ResponseID == responseID) {
+			return record, true
+		}
+	}
+	return launchRecord{}, false
+}
+
+// historyEntry is a launch shown in the history, with what runner submitted for it
+type historyEntry struct {
+	launchRecord
+	Submissions []submissionSummary `json:"submissions,omitempty"`
+}
+
+type historyPage struct {
+	Launches []historyEntry
+
+	// SinkEnabled is set when submissions are received, so the page can say where to send them
+	SinkEnabled bool
+}
+
+// getHistoryHandler shows the recent launches, linked to the submissions received for them
+func (l *launcher) getHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	p := historyPage{SinkEnabled: l.submissions != nil}
+
+	for _, record := range l.history.recent() {
+		entry := historyEntry{launchRecord: record}
+		if l.submissions != nil {
+			entry.Submissions = l.submissions.forLaunch(record.ID)
+		}
+		p.Launches = append(p.Launches, entry)
+	}
+
+	if wantsJSON(r) {
+		launches := p.Launches
+		if launches == nil {
+			launches = []historyEntry{}
+		}
+		writeJSON(w, http.StatusOK, launches)
+		return
+	}
+	serveTemplate("history.html", p, w, r)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/go-launch-a-survey/settings"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// launchValues are the launch form's values for 1_0205.json
+func launchValues() url.Values {
+	return url.Values{
+		"schema":           {"1_0205.json"},
+		"ru_name":          {"ACME"},
+		"ref_p_start_date": {"2020-01-01"},
+		"action_launch":    {"Open Survey"},
+	}
+}
+
+// launchForm launches 1_0205.json through the launch form and returns the token's claims
+func (h *harness) launchForm() map[string]interface{} {
+	resp := h.postForm("/", launchValues())
+	resp.Body.Close()
+	return h.claims(h.tokenFromRedirect(resp, "/session"))
+}
+
+// testSubmissionToken is the SUBMISSION_SINK_TOKEN the tests' runner sends with its submissions
+const testSubmissionToken = "test-submission-token"
+
+// submit posts the payload to the submission sink and returns its response
+func (h *harness) submit(payload string) map[string]interface{} {
+	req, _ := http.NewRequest("POST", h.launcher.URL+submissionsPath, strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testSubmissionToken)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.t.Fatalf("POST %s failed: %s", submissionsPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		h.t.Fatalf("Expected status 201 but recieved %d", resp.StatusCode)
+	}
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return result
+}
+
+// getJSON gets the path from the launcher, decoding its JSON response into v
+func (h *harness) getJSON(path string, v interface{}) {
+	req, _ := http.NewRequest("GET", h.launcher.URL+path, nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.t.Fatalf("GET %s failed: %s", path, err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		h.t.Fatalf("Failed to decode %s: %s", path, err)
+	}
+}
+
+func TestHistoryListsLaunchesNewestFirst(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	first := h.launchForm()
+	resp := h.get("/quick-launch?url=" + url.QueryEscape(h.runner.URL+"/schemas/1/0205"))
+	resp.Body.Close()
+
+	var launches []historyEntry
+	h.getJSON("/history", &launches)
+
+	if len(launches) != 2 {
+		t.Fatalf("Expected 2 launches but recieved %d", len(launches))
+	}
+	if launches[0].Action != "quick-launch" || launches[1].Action != "launch" {
+		t.Errorf("Expected the quick-launch before the launch but recieved %s, %s", launches[0].Action, launches[1].Action)
+	}
+	if launches[1].TxID != first["tx_id"] || launches[1].Schema != "1_0205.json" || launches[1].EqID != "1" {
+		t.Errorf("Expected the launch's claims to be recorded but recieved %+v", launches[1])
+	}
+
+	// The sink is off by default
+	resp = h.get(submissionsPath)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected no submission sink by default but recieved %d", resp.StatusCode)
+	}
+}
+
+func TestLaunchHistoryKeepsTheMostRecent(t *testing.T) {
+	history := newLaunchHistory(2)
+	for _, txID := range []string{"a", "b", "c"} {
+		history.add(launchRecord{TxID: txID})
+	}
+
+	launches := history.recent()
+	if len(launches) != 2 || launches[0].TxID != "c" || launches[1].TxID != "b" {
+		t.Errorf("Expected the 2 newest launches but recieved %+v", launches)
+	}
+	if _, ok := history.find("a", ""); ok {
+		t.Errorf("Expected the oldest launch to be dropped")
+	}
+	if record, ok := history.find("", ""); ok {
+		t.Errorf("Expected no launch for empty identifiers but recieved %+v", record)
+	}
+}
+
+func TestSubmissionSinkIsLimited(t *testing.T) {
+	if _, err := newSubmissionSink(settings.FromValues(nil), newLaunchHistory(1)); err == nil || !strings.Contains(err.Error(), "SUBMISSION_SINK_TOKEN") {
+		t.Errorf("Expected an error for a sink without a token but recieved %v", err)
+	}
+
+	sink, err := newSubmissionSink(settings.FromValues(map[string]string{"LAUNCH_HISTORY_SIZE": "100000", "SUBMISSION_SINK_TOKEN": "secret"}), newLaunchHistory(1))
+	if err != nil {
+		t.Fatalf("Failed to create sink: %s", err)
+	}
+	for i := 0; i < maxSubmissions+1; i++ {
+		sink.receive([]byte(`{}`))
+	}
+	if submissions := sink.recent(); len(submissions) != maxSubmissions || submissions[0].ID != maxSubmissions+1 {
+		t.Errorf("Expected the %d newest submissions but recieved %d", maxSubmissions, len(submissions))
+	}
+
+	h := newHarnessWithSettings(t, map[string]string{"SUBMISSION_SINK_ENABLED": "true"})
+	defer h.Close()
+	req, _ := http.NewRequest("POST", h.launcher.URL+submissionsPath, strings.NewReader(strings.Repeat(" ", maxSubmissionSize+1)))
+	req.Header.Set("Authorization", "Bearer "+testSubmissionToken)
+	if status := h.do(req); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a submission over %d bytes but recieved %d", maxSubmissionSize, status)
+	}
+}
+
+func TestSubmissionsAreLinkedToTheirLaunch(t *testing.T) {
+	h := newHarnessWithSettings(t, map[string]string{"SUBMISSION_SINK_ENABLED": "true"})
+	defer h.Close()
+
+	claims := h.launchForm()
+	result := h.submit(`{"tx_id": "` + claims["tx_id"].(string) + `", "metadata": {"ru_ref": "12345678901A"}, "data": {"1": "yes"}}`)
+	h.submit(`{"tx_id": "unknown"}`)
+
+	var launches []historyEntry
+	h.getJSON("/history", &launches)
+	if len(launches) != 1 || len(launches[0].Submissions) != 1 || float64(launches[0].Submissions[0].ID) != result["id"] {
+		t.Fatalf("Expected the launch to list its submission but recieved %+v", launches)
+	}
+
+	// Only runner knows the sink's token, so nobody else can store submissions
+	for _, value := range []string{"", "Bearer wrong-token"} {
+		if status := h.status("POST", submissionsPath, "Authorization", value); status != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for a submission without the sink's token but recieved %d", status)
+		}
+	}
+
+	var received map[string]interface{}
+	h.getJSON(submissionsPath+"/"+strconv.Itoa(launches[0].Submissions[0].ID), &received)
+	if received["ru_ref"] != "12345678901A" || float64(launches[0].ID) != received["launch_id"] {
+		t.Errorf("Expected the submission's identifiers and launch but recieved %v", received)
+	}
+	if payload, _ := received["payload"].(map[string]interface{}); payload["data"] == nil {
+		t.Errorf("Expected the submission's payload but recieved %v", received["payload"])
+	}
+
+	resp := h.get(submissionsPath + "/" + strconv.Itoa(launches[0].Submissions[0].ID))
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "launch #"+strconv.Itoa(launches[0].ID)) {
+		t.Errorf("Expected the submission page to link to its launch")
+	}
+
+	resp = h.get("/history")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `href="/submissions/`+strconv.Itoa(launches[0].Submissions[0].ID)+`"`) {
+		t.Errorf("Expected the history page to link to the submission")
+	}
+
+	var submissions []map[string]interface{}
+	h.getJSON(submissionsPath, &submissions)
+	if len(submissions) != 2 || submissions[0]["tx_id"] != "unknown" || submissions[0]["launch_id"] != nil {
+		t.Errorf("Expected both submissions, newest first, but recieved %v", submissions)
+	}
+}
+
+func TestEncryptedSubmissionsAreDecrypted(t *testing.T) {
+	// Runner encrypts submissions for the sink, which has the private half of the key
+	keyFile, err := ioutil.TempFile("", "submission-key-*.pem")
+	if err != nil {
+		t.Fatalf("Failed to create key file: %s", err)
+	}
+	defer os.Remove(keyFile.Name())
+	pem.Encode(keyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testRunnerKey(t))})
+	keyFile.Close()
+
+	h := newHarnessWithSettings(t, map[string]string{
+		"SUBMISSION_SINK_ENABLED":        "true",
+		"SUBMISSION_DECRYPTION_KEY_PATH": keyFile.Name(),
+	})
+	defer h.Close()
+
+	claims := h.launchForm()
+
+	encrypter, _ := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: jose.RSA_OAEP, Key: &testRunnerKey(t).PublicKey}, nil)
+	encrypted, _ := encrypter.Encrypt([]byte(`{"tx_id": "` + claims["tx_id"].(string) + `"}`))
+	jwe, _ := encrypted.CompactSerialize()
+
+	if result := h.submit(jwe); result["launch_id"] != float64(1) || result["error"] != "" {
+		t.Errorf("Expected the decrypted submission to be linked to the launch but recieved %v", result)
+	}
+
+	if result := h.submit("not.a.valid.encrypted.payload"); result["error"] == "" {
+		t.Errorf("Expected an error for a payload which can't be decrypted but recieved %v", result)
+	}
+}
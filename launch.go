@@ -1,35 +1,31 @@
 package main // import "github.com/ONSdigital/go-launch-a-survey"
 
 import (
+	"errors"
 	"fmt"
 
 	"html/template"
+	"io/ioutil"
 	"log"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"html"
 
 	"github.com/ONSdigital/go-launch-a-survey/authentication"
+	"github.com/ONSdigital/go-launch-a-survey/clients"
+	"github.com/ONSdigital/go-launch-a-survey/generators"
+	"github.com/ONSdigital/go-launch-a-survey/lint"
 	"github.com/ONSdigital/go-launch-a-survey/settings"
 	"github.com/ONSdigital/go-launch-a-survey/surveys"
 	"github.com/gorilla/mux"
-	uuid "github.com/satori/go.uuid"
 	"gopkg.in/square/go-jose.v2/json"
 )
 
-func randomNumericString(n int) string {
-	var letter = []rune("0123456789")
-
-	output := make([]rune, n)
-	for i := range output {
-		output[i] = letter[rand.Intn(len(letter))]
-	}
-	return string(output)
-}
-
 func serveTemplate(templateName string, data interface{}, w http.ResponseWriter, r *http.Request) {
 	lp := filepath.Join("templates", "layout.html")
 	fp := filepath.Join("templates", filepath.Clean(templateName))
@@ -42,7 +38,9 @@ func serveTemplate(templateName string, data interface{}, w http.ResponseWriter,
 		return
 	}
 
-	tmpl, err := template.ParseFiles(lp, fp)
+	// Forms POSTing to the launcher send back the browser's CSRF token
+	token := csrfToken(w, r)
+	tmpl, err := template.New("layout.html").Funcs(template.FuncMap{"csrfToken": func() string { return token }}).ParseFiles(lp, fp)
 	if err != nil {
 		log.Println(err.Error())
 		http.Error(w, http.StatusText(500), 500)
@@ -55,26 +53,298 @@ func serveTemplate(templateName string, data interface{}, w http.ResponseWriter,
 	}
 }
 
+// targetField is the form/query field naming the runner target to launch into
+const targetField = "target"
+
+// lintField is the quick-launch query field which shows any lint warnings before launching
+const lintField = "lint"
+
+// target holds the settings and services for one runner target
+type target struct {
+	name           string
+	config         *settings.Config
+	surveys        *surveys.Service
+	authentication *authentication.Service
+}
+
+func newTarget(name string, config *settings.Config) *target {
+	httpClient := clients.NewHTTPClient(config)
+	surveysService := surveys.NewService(config, httpClient)
+
+	return &target{
+		name:           name,
+		config:         config,
+		surveys:        surveysService,
+		authentication: authentication.NewService(config, surveysService, httpClient),
+	}
+}
+
+// launcher holds the settings and the runner targets used by the handlers, so several launchers
+// with different settings can run in one process
+type launcher struct {
+	config  *settings.Config
+	targets []*target
+
+	// mockRunner is set when the launcher serves its own mock runner
+	mockRunner *mockRunner
+
+	history *launchHistory
+
+	// submissions is set when the launcher receives runner's submissions
+	submissions *submissionSink
+
+	// access is set when users must be authenticated to use the launcher
+	access *accessControl
+}
+
+func newLauncher(config *settings.Config) (*launcher, error) {
+	targets, err := config.Targets()
+	if err != nil {
+		return nil, err
+	}
+
+	historySize, err := config.GetInt("LAUNCH_HISTORY_SIZE")
+	if err != nil {
+		return nil, err
+	}
+
+	l := &launcher{config: config, history: newLaunchHistory(historySize)}
+	for _, t := range targets {
+		l.targets = append(l.targets, newTarget(t.Name, t.Config))
+	}
+
+	return l, nil
+}
+
+// newServerLauncher creates the launcher for serving, which launches into its own mock runner if
+// MOCK_RUNNER_ENABLED is set, receives runner's submissions if SUBMISSION_SINK_ENABLED is set, and
+// authenticates its users as ACCESS_CONTROL says. The commands use newLauncher, so their tokens are
+// never for the mock runner.
+func newServerLauncher(config *settings.Config) (*launcher, error) {
+	var runner *mockRunner
+	if enabled, _ := config.GetBool("MOCK_RUNNER_ENABLED"); enabled {
+		var err error
+		if runner, err = newMockRunner(config); err != nil {
+			return nil, err
+		}
+		config = config.With(runner.overrides(config), sourceMockRunner)
+	}
+
+	l, err := newLauncher(config)
+	if err != nil {
+		if runner != nil {
+			runner.Close()
+		}
+		return nil, err
+	}
+
+	if runner != nil {
+		l.mockRunner = runner
+		runner.addVerificationKeys(l.targets)
+		log.Println("Serving a mock runner at " + mockRunnerPath)
+	}
+
+	if enabled, _ := config.GetBool("SUBMISSION_SINK_ENABLED"); enabled {
+		if l.submissions, err = newSubmissionSink(config, l.history); err != nil {
+			l.Close()
+			return nil, err
+		}
+		log.Println("Receiving submissions at " + submissionsPath)
+	}
+
+	if l.access, err = newAccessControl(config); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if l.access != nil {
+		log.Println("Users are authenticated with " + config.Get("ACCESS_CONTROL"))
+	}
+
+	if err = l.usePolicy(config); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// usePolicy has each target check the tokens it makes against the policy at ACCESS_POLICY_PATH, if it is set
+func (l *launcher) usePolicy(config *settings.Config) error {
+	policy, err := authentication.LoadPolicy(config.Get("ACCESS_POLICY_PATH"))
+	if err != nil || policy == nil {
+		return err
+	}
+	for _, name := range policy.TargetNames() {
+		if _, err := l.targetNamed(name); err != nil {
+			return fmt.Errorf("ACCESS_POLICY_PATH: %w", err)
+		}
+	}
+
+	for _, t := range l.targets {
+		t.authentication.UsePolicy(t.name, policy)
+	}
+	if l.access == nil {
+		log.Println("WARN: ACCESS_POLICY_PATH is set but ACCESS_CONTROL is none, so only rules for users \"*\" apply")
+	}
+	log.Printf("Launches and flushes are checked against %d policy rules", len(policy.Rules))
+	return nil
+}
+
+// Close releases anything the launcher created, such as the mock runner's key file
+func (l *launcher) Close() {
+	if l.mockRunner != nil {
+		l.mockRunner.Close()
+	}
+}
+
+// targetNotFoundError is returned when a request names a runner target which is not configured
+type targetNotFoundError struct {
+	name string
+}
+
+func (e *targetNotFoundError) Error() string {
+	return "Unknown target: " + e.name
+}
+
+// target returns the runner target named by the request, or the first target if none is named
+func (l *launcher) target(r *http.Request) (*target, error) {
+	return l.targetNamed(r.FormValue(targetField))
+}
+
+// targetNamed returns the runner target with the name, or the first target if the name is empty
+func (l *launcher) targetNamed(name string) (*target, error) {
+	if name == "" {
+		return l.targets[0], nil
+	}
+
+	for _, t := range l.targets {
+		if t.name == name {
+			return t, nil
+		}
+	}
+
+	return nil, &targetNotFoundError{name: name}
+}
+
+func (l *launcher) targetNames() []string {
+	names := make([]string, len(l.targets))
+	for i, t := range l.targets {
+		names[i] = t.name
+	}
+	return names
+}
+
 type page struct {
+	Targets                 []string
+	Target                  string
 	Schemas                 surveys.LauncherSchemas
 	AccountServiceURL       string
 	AccountServiceLogOutURL string
+	Schema                  string
+	Values                  map[string]string
+	Errors                  []authentication.FieldError
 }
 
 func getStatusPage(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
-func getLaunchHandler(w http.ResponseWriter, r *http.Request) {
+func (l *launcher) getLaunchHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := l.target(r)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
+	relaunch := "/"
+	if len(l.targets) > 1 {
+		relaunch += "?" + url.Values{targetField: {t.name}}.Encode()
+	}
+	accountServiceURL, accountServiceLogOutURL := l.accountServiceURLs(r, relaunch)
+
 	p := page{
-		Schemas:                 surveys.GetAvailableSchemas(),
-		AccountServiceURL:       getAccountServiceURL(r),
-		AccountServiceLogOutURL: getAccountServiceURL(r),
+		Targets:                 l.targetNames(),
+		Target:                  t.name,
+		Schemas:                 t.surveys.GetAvailableSchemas(),
+		AccountServiceURL:       accountServiceURL,
+		AccountServiceLogOutURL: accountServiceLogOutURL,
 	}
 	serveTemplate("launch.html", p, w, r)
 }
 
-func postLaunchHandler(w http.ResponseWriter, r *http.Request) {
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeError responds with an error returned by the authentication package, re-rendering the launch
+// page with per-field errors when the submitted metadata failed validation
+func (l *launcher) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Println(err)
+
+	var validationError *authentication.ValidationError
+	if errors.As(err, &validationError) {
+		result := &authentication.SchemaValidationResult{
+			Errors: validationError.Errors,
+			Hash:   validationError.Hash,
+			URL:    r.FormValue("url"),
+		}
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusBadRequest, result)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		serveTemplate("validate.html", validatePage{URL: result.URL, Target: r.FormValue(targetField), Result: result}, w, r)
+		return
+	}
+
+	var metadataError *authentication.MetadataValidationError
+	if errors.As(err, &metadataError) {
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusBadRequest, metadataError)
+			return
+		}
+		if t, targetErr := l.target(r); r.Method == "POST" && targetErr == nil {
+			values := make(map[string]string)
+			for key := range r.PostForm {
+				values[key] = r.PostForm.Get(key)
+			}
+			p := page{
+				Targets:                 l.targetNames(),
+				Target:                  t.name,
+				Schemas:                 t.surveys.GetAvailableSchemas(),
+				AccountServiceURL:       r.PostForm.Get("account_service_url"),
+				AccountServiceLogOutURL: r.PostForm.Get("account_service_log_out_url"),
+				Schema:                  r.PostForm.Get("schema"),
+				Values:                  values,
+				Errors:                  metadataError.Fields,
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			serveTemplate("launch.html", p, w, r)
+			return
+		}
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, errorStatusCode(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	http.Error(w, err.Error(), errorStatusCode(err))
+}
+
+func (l *launcher) postLaunchHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseForm()
 	if err != nil {
@@ -82,18 +352,29 @@ func postLaunchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	redirectURL(w, r)
+	l.redirectURL(w, r)
 }
 
-func getMetadataHandler(w http.ResponseWriter, r *http.Request) {
+func (l *launcher) getMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := l.target(r)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
 	schema := r.URL.Query().Get("schema")
+	seed := generators.ParseSeed(r.URL.Query().Get(authentication.SeedField))
 
-	launcherSchema := surveys.FindSurveyByName(schema)
+	launcherSchema, err := t.surveys.FindSurvey(schema)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
 
-	metadata, err := authentication.GetRequiredMetadata(launcherSchema)
+	metadata, err := t.authentication.GetRequiredMetadata(launcherSchema, seed)
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("GetRequiredMetadata err: %v", err), 500)
+		l.writeError(w, r, err)
 		return
 	}
 
@@ -104,6 +385,33 @@ func getMetadataHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+func (l *launcher) getDefaultsHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := l.target(r)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
+	seed := generators.New(generators.ParseSeed(r.URL.Query().Get(authentication.SeedField))).Seed
+
+	var launcherSchema surveys.LauncherSchema
+	if schema := r.URL.Query().Get("schema"); schema != "" {
+		if launcherSchema, err = t.surveys.FindSurvey(schema); err != nil {
+			l.writeError(w, r, err)
+			return
+		}
+	}
+
+	defaults, err := t.authentication.GetSurveyDefaultValues(launcherSchema, seed)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+	defaults[authentication.SeedField] = strconv.FormatInt(seed, 10)
+
+	writeJSON(w, http.StatusOK, defaults)
+}
+
 func getAccountServiceURL(r *http.Request) string {
 	forwardedProtocol := r.Header.Get("X-Forwarded-Proto")
 
@@ -118,12 +426,67 @@ func getAccountServiceURL(r *http.Request) string {
 		html.EscapeString(r.Host))
 }
 
-func redirectURL(w http.ResponseWriter, r *http.Request) {
-	hostURL := settings.Get("SURVEY_RUNNER_URL")
+// errorStatusCode picks the HTTP status code to respond with for an error returned by the authentication package
+func errorStatusCode(err error) int {
+	var accessErr *accessError
+	if errors.As(err, &accessErr) {
+		return accessErr.StatusCode
+	}
+
+	var targetError *targetNotFoundError
+	if errors.As(err, &targetError) {
+		return http.StatusNotFound
+	}
+
+	var policyError *authentication.PolicyError
+	if errors.As(err, &policyError) {
+		return http.StatusForbidden
+	}
+
+	if err == authentication.ErrNoSchemaValidator {
+		return http.StatusServiceUnavailable
+	}
+
+	var surveyError *surveys.NotFoundError
+	if errors.As(err, &surveyError) {
+		return http.StatusNotFound
+	}
+
+	var validationError *authentication.ValidationError
+	if errors.As(err, &validationError) {
+		return http.StatusBadRequest
+	}
+
+	var metadataError *authentication.MetadataValidationError
+	if errors.As(err, &metadataError) {
+		return http.StatusBadRequest
+	}
+
+	var upstreamError *authentication.UpstreamError
+	if errors.As(err, &upstreamError) {
+		if upstreamError.StatusCode == http.StatusNotFound {
+			return http.StatusNotFound
+		}
+		return http.StatusBadGateway
+	}
+
+	return http.StatusInternalServerError
+}
+
+func (l *launcher) redirectURL(w http.ResponseWriter, r *http.Request) {
+	t, err := l.target(r)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
+	// server_side is an option for the launcher rather than a claim
+	serverSide, _ := strconv.ParseBool(r.PostForm.Get(serverSideField))
+	r.PostForm.Del(serverSideField)
 
-	token, err := authentication.GenerateTokenFromPost(r.PostForm)
-	if err != "" {
-		http.Error(w, err, 500)
+	claims, err := t.authentication.ClaimsFromPost(r.PostForm, requestPrincipal(r))
+	if err != nil {
+		l.writeError(w, r, err)
 		return
 	}
 
@@ -131,65 +494,325 @@ func redirectURL(w http.ResponseWriter, r *http.Request) {
 	flushAction := r.PostForm.Get("action_flush")
 	log.Println("Request: " + r.PostForm.Encode())
 
+	if serverSide && launchAction != "" && flushAction == "" {
+		l.startSession(w, r, t, claims, newLaunchRecord(t.name, "launch", r.PostForm.Get("schema"), claims))
+		return
+	}
+
+	token, err := t.authentication.GenerateTokenFromClaims(claims)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
+	action, endpoint := "launch", authentication.SessionEndpoint
 	if flushAction != "" {
-		http.Redirect(w, r, hostURL+"/flush?token="+token, 307)
-	} else if launchAction != "" {
-		http.Redirect(w, r, hostURL+"/session?token="+token, 301)
-	} else {
+		action, endpoint = "flush", authentication.FlushEndpoint
+	} else if launchAction == "" {
 		http.Error(w, fmt.Sprintf("Invalid Action"), 500)
+		return
 	}
+
+	runnerRequest, err := newRunnerRequest(t, endpoint, token)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
+	l.recordLaunch(r, newLaunchRecord(t.name, action, r.PostForm.Get("schema"), claims))
+	runnerRequest.send(w, r)
 }
 
-func quickLauncherHandler(w http.ResponseWriter, r *http.Request) {
-	hostURL := settings.Get("SURVEY_RUNNER_URL")
-	accountServiceURL := getAccountServiceURL(r)
-	AccountServiceLogOutURL := getAccountServiceURL(r)
+func (l *launcher) quickLauncherHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := l.target(r)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
 	urlValues := r.URL.Query()
 	surveyURL := urlValues.Get("url")
-	log.Println("Quick launch request received", surveyURL)
+	log.Println("Quick launch request received", t.name, surveyURL)
+
+	// Fix the seed so the generated ru_ref, case_id etc. and the metadata defaults are consistent
+	generator := generators.New(generators.ParseSeed(urlValues.Get(authentication.SeedField)))
+	urlValues.Set(authentication.SeedField, strconv.FormatInt(generator.Seed, 10))
+
+	// lint is an option for the launcher rather than a claim
+	lintRequested, _ := strconv.ParseBool(urlValues.Get(lintField))
+	urlValues.Del(lintField)
+	serverSide, _ := strconv.ParseBool(urlValues.Get(serverSideField))
+	urlValues.Del(serverSideField)
+
+	// The account service pages relaunch with the same seed, so with the same generated values
+	accountServiceURL, accountServiceLogOutURL := l.accountServiceURLs(r, "/quick-launch?"+urlValues.Encode())
+
+	claims, err := t.authentication.ClaimsFromURL(surveyURL, accountServiceURL, accountServiceLogOutURL, urlValues)
+	if err == nil {
+		err = t.authentication.Authorize(requestPrincipal(r), authentication.ActionLaunch, claims)
+	}
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
 
-	collectionExerciseUUID := uuid.NewV4()
+	record := newLaunchRecord(t.name, "quick-launch", surveyURL, claims)
+	if serverSide {
+		l.startSession(w, r, t, claims, record)
+		return
+	}
 
-	caseUUID := uuid.NewV4()
+	var warnings []lint.Warning
+	if lintRequested {
+		if warnings, err = t.authentication.LintSchemaFromURL(surveyURL); err != nil {
+			l.writeError(w, r, err)
+			return
+		}
+	}
 
-	urlValues.Add("ru_ref", authentication.GetDefaultValues()["ru_ref"])
-	urlValues.Add("collection_exercise_sid", collectionExerciseUUID.String())
-	urlValues.Add("case_id", caseUUID.String())
-	urlValues.Add("response_id", randomNumericString(16))
+	token, err := t.authentication.GenerateTokenFromClaims(claims)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
 
-	token, err := authentication.GenerateTokenFromDefaults(surveyURL, accountServiceURL, AccountServiceLogOutURL, urlValues)
-	if err != "" {
-		http.Error(w, err, 400)
+	runnerRequest, err := newRunnerRequest(t, authentication.SessionEndpoint, token)
+	if err != nil {
+		l.writeError(w, r, err)
 		return
 	}
+	l.recordLaunch(r, record)
 
-	if surveyURL != "" {
-		http.Redirect(w, r, hostURL+"/session?token="+token, 302)
-	} else {
-		http.Error(w, fmt.Sprintf("Not Found"), 404)
+	// With lint set, stop to show any lint warnings before continuing to the survey
+	if len(warnings) > 0 {
+		result := &authentication.SchemaValidationResult{Valid: true, Warnings: warnings, URL: surveyURL}
+		w.Header().Set("Cache-Control", "no-store")
+		serveTemplate("validate.html", validatePage{URL: surveyURL, Target: t.name, Result: result, Launch: &runnerRequest}, w, r)
+		return
 	}
+
+	runnerRequest.send(w, r)
 }
 
-func main() {
+type validatePage struct {
+	URL    string
+	Target string
+	Result *authentication.SchemaValidationResult
+	Error  string
+
+	// Launch continues a quick-launch which stopped to show lint warnings
+	Launch *runnerRequest
+}
+
+// getValidateHandler lints and validates the schema at the url parameter without launching it
+func (l *launcher) getValidateHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := l.target(r)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
+	p := validatePage{URL: r.URL.Query().Get("url"), Target: t.name}
+	if p.URL != "" {
+		p.Result, err = t.authentication.CheckSchemaFromURL(p.URL)
+		if err != nil {
+			if wantsJSON(r) {
+				l.writeError(w, r, err)
+				return
+			}
+			log.Println(err)
+			p.Error = err.Error()
+			w.WriteHeader(errorStatusCode(err))
+		}
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, p.Result)
+		return
+	}
+
+	serveTemplate("validate.html", p, w, r)
+}
+
+// postValidateHandler lints and validates the schema in the request body without launching it
+func (l *launcher) postValidateHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := l.target(r)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read schema: %v", err), 400)
+		return
+	}
+
+	result, err := t.authentication.CheckSchema(payload)
+	if err != nil {
+		log.Println(err)
+		writeJSON(w, errorStatusCode(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// getLintHandler lints the schema at the url parameter, without using the schema validator
+func (l *launcher) getLintHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := l.target(r)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+
+	warnings, err := t.authentication.LintSchemaFromURL(r.URL.Query().Get("url"))
+	if err != nil {
+		log.Println(err)
+		writeJSON(w, errorStatusCode(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"warnings": warnings})
+}
+
+// postLintHandler lints the schema in the request body, without using the schema validator
+func (l *launcher) postLintHandler(w http.ResponseWriter, r *http.Request) {
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read schema: %v", err), 400)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"warnings": lint.Schema(payload)})
+}
+
+// targetSummary describes a runner target for the /targets API
+type targetSummary struct {
+	Name              string `json:"name"`
+	SurveyRunnerURL   string `json:"survey_runner_url"`
+	SurveyRegisterURL string `json:"survey_register_url"`
+}
+
+func (l *launcher) getTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	summaries := make([]targetSummary, len(l.targets))
+	for i, t := range l.targets {
+		summaries[i] = targetSummary{
+			Name:              t.name,
+			SurveyRunnerURL:   t.config.Get("SURVEY_RUNNER_URL"),
+			SurveyRegisterURL: t.config.Get("SURVEY_REGISTER_URL"),
+		}
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (l *launcher) getSchemasHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := l.target(r)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t.surveys.GetAvailableSchemas())
+}
+
+func (l *launcher) getConfigHandler(w http.ResponseWriter, r *http.Request) {
+	enabled, _ := l.config.GetBool("CONFIG_VIEW_ENABLED")
+	if !enabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, l.config.All())
+		return
+	}
+
+	serveTemplate("config.html", l.config.All(), w, r)
+}
+
+func (l *launcher) router() *mux.Router {
 	r := mux.NewRouter()
 
 	// Launch handlers
-	r.HandleFunc("/", getLaunchHandler).Methods("GET")
-	r.HandleFunc("/", postLaunchHandler).Methods("POST")
-	r.HandleFunc("/metadata", getMetadataHandler).Methods("GET")
+	r.HandleFunc("/", l.getLaunchHandler).Methods("GET")
+	r.HandleFunc("/", requireCSRFToken(l.postLaunchHandler)).Methods("POST")
+	r.HandleFunc("/metadata", l.getMetadataHandler).Methods("GET")
+	r.HandleFunc("/defaults", l.getDefaultsHandler).Methods("GET")
+	r.HandleFunc("/targets", l.getTargetsHandler).Methods("GET")
+	r.HandleFunc("/validate", l.getValidateHandler).Methods("GET")
+	r.HandleFunc("/validate", requireCSRFToken(l.postValidateHandler)).Methods("POST")
+	r.HandleFunc("/bulk", requireAPIKey(l.bulkHandler)).Methods("GET")
+	r.HandleFunc("/bulk", requireCSRFToken(l.bulkHandler)).Methods("POST")
+	r.HandleFunc("/flush", l.getFlushHandler).Methods("GET")
+	r.HandleFunc("/flush", requireCSRFToken(l.postFlushHandler)).Methods("POST")
+	r.HandleFunc("/lint", l.getLintHandler).Methods("GET")
+	r.HandleFunc("/lint", requireCSRFToken(l.postLintHandler)).Methods("POST")
+	r.HandleFunc("/schemas", l.getSchemasHandler).Methods("GET")
 	//Author Launcher with passed parameters in Url
-	r.HandleFunc("/quick-launch", quickLauncherHandler).Methods("GET")
+	r.HandleFunc("/quick-launch", l.quickLauncherHandler).Methods("GET")
 
 	// Status Page
 	r.HandleFunc("/status", getStatusPage).Methods("GET")
 
+	// Effective settings
+	r.HandleFunc("/config", l.getConfigHandler).Methods("GET")
+
+	// Recent launches, and what runner submitted for them
+	r.HandleFunc("/history", l.getHistoryHandler).Methods("GET")
+	if l.submissions != nil {
+		l.submissions.register(r)
+	}
+
+	// Login pages, when users are authenticated
+	if l.access != nil {
+		l.access.register(r)
+	}
+
+	// Mock account service pages, which runner links to
+	l.registerAccountService(r)
+
+	// Stand-in for runner, when enabled
+	if l.mockRunner != nil {
+		l.mockRunner.register(r)
+	}
+
 	// Serve static assets
 	staticFs := http.FileServer(http.Dir("static"))
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", staticFs))
 
+	return r
+}
+
+// handler is the router behind the launcher's access control, if it is on
+func (l *launcher) handler() http.Handler {
+	if l.access == nil {
+		return l.router()
+	}
+	return l.access.wrap(l.router())
+}
+
+func main() {
+	config := settings.FromEnvironment()
+
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(config, os.Args[1:], os.Stdout, os.Stderr))
+	}
+
+	if errs := config.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Println("Invalid setting:", err)
+		}
+		log.Fatal("Refusing to start with invalid settings")
+	}
+
+	l, err := newServerLauncher(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Bind to a port and pass our router in
-	hostname := settings.Get("GO_LAUNCH_A_SURVEY_LISTEN_HOST") + ":" + settings.Get("GO_LAUNCH_A_SURVEY_LISTEN_PORT")
+	hostname := config.Get("GO_LAUNCH_A_SURVEY_LISTEN_HOST") + ":" + config.Get("GO_LAUNCH_A_SURVEY_LISTEN_PORT")
 
 	log.Println("Listening on " + hostname)
-	log.Fatal(http.ListenAndServe(hostname, r))
+	log.Fatal(http.ListenAndServe(hostname, l.handler()))
 }
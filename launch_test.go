@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+	"github.com/ONSdigital/go-launch-a-survey/surveys"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+func TestLaunchPageListsRunnerAndRegisterSchemas(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.get("/")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 but recieved %d", resp.StatusCode)
+	}
+	for _, expected := range []string{"1_0205.json", "test_checkbox.json", "Ecommerce"} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected the launch page to list %s", expected)
+		}
+	}
+}
+
+func TestLaunchFormSessionHasTheSubmittedClaims(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.postForm("/", url.Values{
+		"schema":           {"1_0205.json"},
+		"ru_name":          {"ACME"},
+		"ref_p_start_date": {"2020-01-01"},
+		"trad_as":          {""},
+		"action_launch":    {"Open Survey"},
+	})
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected status 303 but recieved %d", resp.StatusCode)
+	}
+	claims := h.claims(h.tokenFromRedirect(resp, "/session"))
+
+	expected := map[string]string{
+		"eq_id":            "1",
+		"form_type":        "0205",
+		"ru_name":          "ACME",
+		"ref_p_start_date": "2020-01-01",
+	}
+	for name, value := range expected {
+		if claims[name] != value {
+			t.Errorf("Expected claim %s to be %q but recieved %v", name, value, claims[name])
+		}
+	}
+	if _, ok := claims["trad_as"]; ok {
+		t.Errorf("Expected the blank optional trad_as to be left out of the token")
+	}
+	for _, name := range []string{"tx_id", "iat", "exp"} {
+		if _, ok := claims[name]; !ok {
+			t.Errorf("Expected the token to have a %s claim", name)
+		}
+	}
+}
+
+func TestLaunchFormRedirectIsAcceptedByRunner(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	// Follow the redirects through to the fake runner, keeping its session cookie, as a browser would
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar}
+	resp, err := browser.Do(newFormRequest(h.launcher.URL, "/", url.Values{
+		"schema":           {"1_0205.json"},
+		"ru_name":          {"ACME"},
+		"ref_p_start_date": {"2020-01-01"},
+		"action_launch":    {"Open Survey"},
+	}))
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/questionnaire/1/0205/" {
+		t.Fatalf("Expected to end at runner's questionnaire but recieved %d from %s", resp.StatusCode, resp.Request.URL)
+	}
+	if sessions := h.runnerSessions(); len(sessions) != 1 || sessions[0]["ru_name"] != "ACME" {
+		t.Errorf("Expected runner to receive one session for ACME but recieved %v", sessions)
+	}
+}
+
+func TestLaunchFormFlushUsesRunnersFlushEndpoint(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.postForm("/", url.Values{
+		"schema":           {"1_0205.json"},
+		"ru_name":          {"ACME"},
+		"ref_p_start_date": {"2020-01-01"},
+		"action_flush":     {"Flush Survey Data"},
+	})
+	defer resp.Body.Close()
+
+	// Runner only accepts flushes as POSTs, so the browser POSTs the token rather than re-POSTing the launch form
+	claims := h.claims(h.tokenFromForm(resp, "/flush", false))
+	if claims["ru_name"] != "ACME" {
+		t.Errorf("Expected the flush token to be for ACME but recieved %v", claims["ru_name"])
+	}
+}
+
+func TestLaunchFormReportsInvalidMetadata(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.postForm("/", url.Values{
+		"schema":           {"1_0205.json"},
+		"ru_name":          {"ACME"},
+		"ref_p_start_date": {"not a date"},
+		"action_launch":    {"Open Survey"},
+	})
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 but recieved %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "YYYY-MM-DD") {
+		t.Errorf("Expected the form to show the date error but recieved %s", body)
+	}
+}
+
+func TestLaunchFormForRegisterSurvey(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.postForm("/", url.Values{
+		"schema":          {surveys.RegisterSchemaID("187", "002", "2")},
+		"ru_name":         {"ACME"},
+		"employment_date": {"2020-02-01"},
+		"action_launch":   {"Open Survey"},
+	})
+	resp.Body.Close()
+
+	claims := h.claims(h.tokenFromRedirect(resp, "/session"))
+	if claims["eq_id"] != "ecommerce" || claims["employment_date"] != "2020-02-01" {
+		t.Errorf("Expected the register survey's claims but recieved %v", claims)
+	}
+}
+
+func TestMetadataIsLoadedFromRunner(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.get("/metadata?schema=1_0205.json")
+	defer resp.Body.Close()
+
+	var metadata []authentication.Metadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if len(metadata) != 3 || metadata[0].Name != "ru_name" {
+		t.Errorf("Expected the schema's three metadata entries but recieved %v", metadata)
+	}
+}
+
+func TestDefaultsReportsABrokenDefaultsConfig(t *testing.T) {
+	defaultsFile, err := ioutil.TempFile("", "defaults-*.json")
+	if err != nil {
+		t.Fatalf("Failed to create defaults file: %s", err)
+	}
+	defer os.Remove(defaultsFile.Name())
+	defaultsFile.WriteString(`{"defaults": `)
+	defaultsFile.Close()
+
+	h := newHarnessWithSettings(t, map[string]string{"DEFAULTS_CONFIG_PATH": defaultsFile.Name()})
+	defer h.Close()
+
+	var result map[string]string
+	h.getJSON("/defaults?schema=1_0205.json", &result)
+	if !strings.HasPrefix(result["error"], "Failed to parse defaults config") {
+		t.Errorf("Expected the defaults config's error as JSON but recieved %v", result)
+	}
+}
+
+func TestQuickLaunchUsesDefaultsForMissingMetadata(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.get("/quick-launch?ru_name=ACME&url=" + url.QueryEscape(h.runner.URL+"/schemas/1/0205"))
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected status 303 but recieved %d", resp.StatusCode)
+	}
+	claims := h.claims(h.tokenFromRedirect(resp, "/session"))
+
+	if claims["ru_name"] != "ACME" {
+		t.Errorf("Expected the given ru_name but recieved %v", claims["ru_name"])
+	}
+	if claims["ref_p_start_date"] == "" || claims["ref_p_start_date"] == nil {
+		t.Errorf("Expected a default ref_p_start_date")
+	}
+	if accountServiceURL, _ := claims["account_service_url"].(string); !strings.HasPrefix(accountServiceURL, h.launcher.URL+"/account-service?") {
+		t.Errorf("Expected the account service to be the launcher's but recieved %v", claims["account_service_url"])
+	}
+}
+
+func TestQuickLaunchRejectsSchemasFailingValidation(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.get("/quick-launch?url=" + url.QueryEscape(h.runner.URL+"/schemas/invalid/0001"))
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 but recieved %d", resp.StatusCode)
+	}
+	if sessions := h.runnerSessions(); len(sessions) != 0 {
+		t.Errorf("Expected no sessions but recieved %v", sessions)
+	}
+}
+
+func TestValidateReportsValidatorErrors(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	req, _ := http.NewRequest("GET", h.launcher.URL+"/validate?url="+url.QueryEscape(h.runner.URL+"/schemas/invalid/0001"), nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	defer resp.Body.Close()
+
+	var result authentication.SchemaValidationResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if result.Valid || len(result.Errors) != 1 || result.Errors[0].Pointer != "/eq_id" {
+		t.Errorf("Expected the validator's error but recieved %+v", result)
+	}
+}
+
+func TestBulkTokensAreDistinct(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.postForm("/bulk", url.Values{"schema": {"1_0205.json"}, "count": {"3"}, "claim": {"ru_name=Company {{.Index}}"}})
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 but recieved %d", resp.StatusCode)
+	}
+
+	caseIDs := make(map[interface{}]bool)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for index := 0; scanner.Scan(); index++ {
+		var token authentication.BulkToken
+		if err := json.Unmarshal(scanner.Bytes(), &token); err != nil {
+			t.Fatalf("Error %s recieved, expected nil", err)
+		}
+		claims := h.claims(token.Token)
+		if claims["ru_name"] != "Company "+strconv.Itoa(index) {
+			t.Errorf("Expected token %d to be for Company %d but recieved %v", index, index, claims["ru_name"])
+		}
+		caseIDs[claims["case_id"]] = true
+	}
+
+	if len(caseIDs) != 3 {
+		t.Errorf("Expected 3 distinct case_ids but recieved %v", caseIDs)
+	}
+}
+
+func TestBulkTokensAsCSV(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	// The format is checked before the schema is looked for
+	resp := h.postForm("/bulk", url.Values{"schema": {"missing.json"}, "count": {"1"}, "format": {"xml"}})
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || strings.TrimSpace(string(body)) != `Unknown format "xml"; expected csv or ndjson` {
+		t.Errorf("Expected the unknown format to be reported but recieved %d: %s", resp.StatusCode, body)
+	}
+
+	resp = h.postForm("/bulk", url.Values{"schema": {"1_0205.json"}, "count": {"250"}, "format": {"csv"}})
+	defer resp.Body.Close()
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if len(rows) != 251 || rows[0][0] != "index" || rows[250][0] != "249" {
+		t.Errorf("Expected a header and 250 tokens but recieved %d rows", len(rows))
+	}
+}
@@ -0,0 +1,169 @@
+// Package lint checks the parts of a questionnaire schema the launcher depends on, so common
+// mistakes are reported even when no schema validator is available
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// Warning is a problem found in a schema. Warnings do not stop a schema being launched.
+type Warning struct {
+	// Pointer is a JSON pointer to the part of the schema the warning is about
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+// Validators are the metadata validators the launcher understands
+var Validators = []string{"boolean", "date", "integer", "string", "uuid"}
+
+// reservedClaims are set by the launcher itself, so metadata with these names is overwritten
+var reservedClaims = []string{"eq_id", "exp", "form_type", "iat", "jti", "roles", "survey_url", "tx_id"}
+
+// recommended properties are not used by the launcher but are expected by runner
+var recommended = []string{"data_version", "schema_version", "survey_id", "title"}
+
+var (
+	eqIDRegex     = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	languageRegex = regexp.MustCompile(`^[a-z]{2}$`)
+)
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type linter struct {
+	warnings []Warning
+}
+
+func (l *linter) warn(pointer string, format string, args ...interface{}) {
+	l.warnings = append(l.warnings, Warning{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) requireString(schema map[string]interface{}, key string) (string, bool) {
+	value, present := schema[key]
+	if !present {
+		l.warn("/"+key, "%s is missing; the launcher needs it to identify the questionnaire", key)
+		return "", false
+	}
+	s, ok := value.(string)
+	if !ok || s == "" {
+		l.warn("/"+key, "%s must be a non-empty string", key)
+		return "", false
+	}
+	return s, true
+}
+
+func (l *linter) metadata(schema map[string]interface{}) {
+	value, present := schema["metadata"]
+	if !present {
+		l.warn("/metadata", "metadata is missing; no survey metadata will be sent to runner")
+		return
+	}
+	entries, ok := value.([]interface{})
+	if !ok {
+		l.warn("/metadata", "metadata must be a list")
+		return
+	}
+
+	seen := make(map[string]int)
+	for i, value := range entries {
+		pointer := fmt.Sprintf("/metadata/%d", i)
+		entry, ok := value.(map[string]interface{})
+		if !ok {
+			l.warn(pointer, "metadata entries must be objects")
+			continue
+		}
+
+		name, _ := entry["name"].(string)
+		if name == "" {
+			l.warn(pointer+"/name", "metadata entry has no name")
+		} else {
+			if first, duplicate := seen[name]; duplicate {
+				l.warn(pointer+"/name", "%s is already defined at /metadata/%d", name, first)
+			} else {
+				seen[name] = i
+			}
+			if contains(reservedClaims, name) {
+				l.warn(pointer+"/name", "%s is set by the launcher and will be overwritten", name)
+			}
+			if strings.TrimSpace(name) != name {
+				l.warn(pointer+"/name", "%q has leading or trailing whitespace", name)
+			}
+		}
+
+		validatorKey := "validator"
+		if _, ok := entry["validator"]; !ok {
+			// The newer schema format names the validator type
+			validatorKey = "type"
+		}
+		validator, _ := entry[validatorKey].(string)
+		if validator == "" {
+			l.warn(pointer, "metadata entry %s has no validator; it will be treated as a required string", name)
+		} else if !contains(Validators, validator) {
+			l.warn(pointer+"/"+validatorKey, "unknown validator %q; expected one of %s", validator, strings.Join(Validators, ", "))
+		}
+
+		if optional, present := entry["optional"]; present {
+			if _, ok := optional.(bool); !ok {
+				l.warn(pointer+"/optional", "optional must be true or false")
+			}
+		}
+	}
+}
+
+func (l *linter) languages(schema map[string]interface{}) {
+	if language, present := schema["language"]; present {
+		if s, _ := language.(string); !languageRegex.MatchString(s) {
+			l.warn("/language", "language must be a two letter language code such as \"en\" or \"cy\"")
+		}
+	}
+
+	if languages, present := schema["languages"]; present {
+		codes, ok := languages.([]interface{})
+		if !ok {
+			l.warn("/languages", "languages must be a list of language codes")
+			return
+		}
+		for i, code := range codes {
+			if s, _ := code.(string); !languageRegex.MatchString(s) {
+				l.warn(fmt.Sprintf("/languages/%d", i), "%v is not a two letter language code", code)
+			}
+		}
+	}
+}
+
+// Schema checks the schema and returns the warnings found
+func Schema(payload []byte) []Warning {
+	l := &linter{warnings: []Warning{}}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(payload, &schema); err != nil {
+		l.warn("", "schema is not a JSON object: %v", err)
+		return l.warnings
+	}
+
+	if eqID, ok := l.requireString(schema, "eq_id"); ok && !eqIDRegex.MatchString(eqID) {
+		l.warn("/eq_id", "eq_id %q should only contain lower case letters, numbers, hyphens and underscores", eqID)
+	}
+	l.requireString(schema, "form_type")
+
+	l.metadata(schema)
+	l.languages(schema)
+
+	for _, key := range recommended {
+		if _, present := schema[key]; !present {
+			l.warn("/"+key, "%s is missing; runner expects it", key)
+		}
+	}
+
+	return l.warnings
+}
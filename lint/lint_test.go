@@ -0,0 +1,52 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+)
+
+func pointers(warnings []Warning) string {
+	var p []string
+	for _, warning := range warnings {
+		p = append(p, warning.Pointer)
+	}
+	return strings.Join(p, " ")
+}
+
+func TestSchemaWithoutProblems(t *testing.T) {
+	warnings := Schema([]byte(`{
+		"eq_id": "census", "form_type": "household", "title": "Census", "survey_id": "census",
+		"schema_version": "0.0.1", "data_version": "0.0.2", "language": "en",
+		"metadata": [
+			{"name": "ru_ref", "validator": "string"},
+			{"name": "trad_as", "type": "string", "optional": true}
+		]
+	}`))
+	if len(warnings) != 0 {
+		t.Errorf("Expected no warnings but recieved %v", warnings)
+	}
+}
+
+func TestSchemaReportsMistakes(t *testing.T) {
+	warnings := Schema([]byte(`{
+		"eq_id": "Census 2021", "title": "Census", "survey_id": "census",
+		"schema_version": "0.0.1", "data_version": "0.0.2", "languages": ["en", "welsh"],
+		"metadata": [
+			{"name": "ru_ref", "validator": "string"},
+			{"name": "ru_ref", "validator": "text"},
+			{"name": "tx_id", "validator": "uuid", "optional": "yes"}
+		]
+	}`))
+
+	expected := "/eq_id /form_type /metadata/1/name /metadata/1/validator /metadata/2/name /metadata/2/optional /languages/1"
+	if pointers(warnings) != expected {
+		t.Errorf("Expected warnings at %s but recieved %v", expected, warnings)
+	}
+}
+
+func TestSchemaReportsInvalidJSON(t *testing.T) {
+	warnings := Schema([]byte(`[]`))
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Message, "not a JSON object") {
+		t.Errorf("Expected a single warning for the invalid schema but recieved %v", warnings)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+	"github.com/ONSdigital/go-launch-a-survey/settings"
+	"github.com/gorilla/mux"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// mockRunnerPath is where the mock runner is served by the launcher
+const mockRunnerPath = "/mock-runner"
+
+// mockRunnerSchemasPath is the directory of sample schemas served by the mock runner
+const mockRunnerSchemasPath = "mock-runner"
+
+// sourceMockRunner is the source of settings overridden to launch into the mock runner
+const sourceMockRunner settings.Source = "mock-runner"
+
+// mockRunner stands in for Survey Runner so the launcher can be demonstrated without it. It serves
+// sample schemas, and decrypts and verifies the tokens it is launched with to show their claims.
+type mockRunner struct {
+	schemasPath   string
+	decryptionKey *rsa.PrivateKey
+
+	// encryptionKeyPath is a generated public key file, used when runner's private key isn't configured
+	encryptionKeyPath string
+
+	verificationKeys map[string]*rsa.PublicKey
+}
+
+func newMockRunner(config *settings.Config) (*mockRunner, error) {
+	m := &mockRunner{
+		schemasPath:      config.Get("MOCK_RUNNER_SCHEMAS_PATH"),
+		verificationKeys: make(map[string]*rsa.PublicKey),
+	}
+	if m.schemasPath == "" {
+		m.schemasPath = mockRunnerSchemasPath
+	}
+
+	if path := config.Get("MOCK_RUNNER_DECRYPTION_KEY_PATH"); path != "" {
+		key, err := authentication.LoadDecryptionKey(path)
+		if err != nil {
+			return nil, err
+		}
+		m.decryptionKey = key
+		return m, nil
+	}
+
+	// Without runner's key, generate a key pair and encrypt tokens with its public half
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate mock runner key: %w", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal mock runner key: %w", err)
+	}
+
+	file, err := ioutil.TempFile("", "mock-runner-public-key-*.pem")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write mock runner key: %w", err)
+	}
+	defer file.Close()
+	if err := pem.Encode(file, &pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}); err != nil {
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to write mock runner key: %w", err)
+	}
+
+	m.decryptionKey = key
+	m.encryptionKeyPath = file.Name()
+	return m, nil
+}
+
+// overrides are the settings which make the launcher launch into the mock runner. Runner's URL is
+// only replaced if it hasn't been set. Browsers are sent to MOCK_RUNNER_URL, while the launcher loads
+// the mock runner's schemas from itself.
+func (m *mockRunner) overrides(config *settings.Config) map[string]string {
+	overrides := make(map[string]string)
+	if config.Source("SURVEY_RUNNER_URL") == settings.SourceDefault {
+		localURL := "http://localhost:" + config.Get("GO_LAUNCH_A_SURVEY_LISTEN_PORT") + mockRunnerPath
+		overrides["SURVEY_RUNNER_URL"] = localURL
+		if publicURL := config.Get("MOCK_RUNNER_URL"); publicURL != "" {
+			overrides["SURVEY_RUNNER_URL"] = strings.TrimSuffix(publicURL, "/")
+		}
+		if config.Source("SURVEY_RUNNER_SCHEMA_URL") == settings.SourceDefault {
+			overrides["SURVEY_RUNNER_SCHEMA_URL"] = localURL
+		}
+	}
+	if m.encryptionKeyPath != "" {
+		overrides["JWT_ENCRYPTION_KEY_PATH"] = m.encryptionKeyPath
+	}
+	return overrides
+}
+
+// addVerificationKeys trusts the signing keys of the targets, as runner trusts the launcher's key
+func (m *mockRunner) addVerificationKeys(targets []*target) {
+	for _, t := range targets {
+		kid, key, err := t.authentication.VerificationKey()
+		if err != nil {
+			log.Printf("WARN: Mock runner can't verify tokens from target %s: %s", t.name, err)
+			continue
+		}
+		m.verificationKeys[kid] = key
+	}
+}
+
+// Close removes the generated key file
+func (m *mockRunner) Close() {
+	if m.encryptionKeyPath != "" {
+		os.Remove(m.encryptionKeyPath)
+	}
+}
+
+func (m *mockRunner) register(r *mux.Router) {
+	s := r.PathPrefix(mockRunnerPath).Subrouter()
+	s.HandleFunc("/schemas", m.getSchemasHandler).Methods("GET")
+	s.HandleFunc("/schemas/{eq_id}/{form_type}", m.getSchemaHandler).Methods("GET")
+	s.HandleFunc("/session", m.tokenHandler("session")).Methods("GET", "POST")
+	s.HandleFunc("/flush", m.tokenHandler("flush")).Methods("GET", "POST")
+}
+
+func (m *mockRunner) getSchemasHandler(w http.ResponseWriter, r *http.Request) {
+	paths, err := filepath.Glob(filepath.Join(m.schemasPath, "*.json"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	schemas := make([]string, len(paths))
+	for i, path := range paths {
+		schemas[i] = filepath.Base(path)
+	}
+	sort.Strings(schemas)
+
+	writeJSON(w, http.StatusOK, schemas)
+}
+
+// schema reads the sample schema for the eq_id and form_type, which is named like runner's schema files
+func (m *mockRunner) schema(eqID string, formType string) ([]byte, error) {
+	filename := eqID + "_" + formType + ".json"
+	if filename != filepath.Base(filename) {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.ReadFile(filepath.Join(m.schemasPath, filename))
+}
+
+func (m *mockRunner) getSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	schema, err := m.schema(vars["eq_id"], vars["form_type"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(schema)
+}
+
+// mockRunnerClaim is a claim shown by the mock runner, marked if the schema expects it but it is missing
+type mockRunnerClaim struct {
+	Name     string
+	Value    string
+	Metadata bool
+	Optional bool
+	Missing  bool
+}
+
+type mockRunnerPage struct {
+	Action string
+	Token  *authentication.DecryptedToken
+	Error  string
+	Claims []mockRunnerClaim
+
+	// Schema is the title of the sample schema the token launches, if there is one
+	Schema string
+}
+
+// tokenHandler decrypts and verifies the token runner would receive for the action, and shows its claims
+func (m *mockRunner) tokenHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := mockRunnerPage{Action: action}
+
+		token, err := authentication.DecryptToken(r.FormValue("token"), m.decryptionKey, m.verificationKeys)
+		p.Token = token
+		if err != nil {
+			log.Println("Mock runner rejected token:", err)
+			p.Error = err.Error()
+		}
+		if token != nil {
+			p.Claims, p.Schema = m.claims(token.Claims)
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		serveTemplate("mock-runner.html", p, w, r)
+	}
+}
+
+// claims lists the token's claims, checking them against the metadata of the sample schema if there is one
+func (m *mockRunner) claims(claims map[string]interface{}) ([]mockRunnerClaim, string) {
+	var schema struct {
+		authentication.QuestionnaireSchema
+		Title string `json:"title"`
+	}
+	eqID, _ := claims["eq_id"].(string)
+	formType, _ := claims["form_type"].(string)
+	if payload, err := m.schema(eqID, formType); err == nil {
+		json.Unmarshal(payload, &schema)
+	}
+
+	metadata := make(map[string]authentication.Metadata)
+	for _, entry := range schema.Metadata {
+		metadata[entry.Name] = entry
+	}
+
+	var listed []mockRunnerClaim
+	for name, value := range claims {
+		entry, isMetadata := metadata[name]
+		listed = append(listed, mockRunnerClaim{Name: name, Value: claimString(name, value), Metadata: isMetadata, Optional: entry.Optional})
+	}
+	for name, entry := range metadata {
+		if _, ok := claims[name]; !ok {
+			listed = append(listed, mockRunnerClaim{Name: name, Metadata: true, Optional: entry.Optional, Missing: !entry.Optional})
+		}
+	}
+
+	sort.Slice(listed, func(i, j int) bool { return listed[i].Name < listed[j].Name })
+	return listed, schema.Title
+}
+
+// claimString formats a claim for display, showing the times of iat and exp
+func claimString(name string, value interface{}) string {
+	if seconds, ok := value.(float64); ok && (name == "iat" || name == "exp") {
+		return time.Unix(int64(seconds), 0).UTC().Format(time.RFC3339)
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	encoded, _ := json.Marshal(value)
+	return strings.TrimSpace(string(encoded))
+}
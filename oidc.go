@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// loginCookie holds the state of a login while the user is at the OIDC issuer
+const loginCookie = "launcher_login"
+
+// loginLifetime limits how long a user has to log in at the OIDC issuer
+const loginLifetime = 10 * time.Minute
+
+// clockSkew allows for the launcher's clock differing from the OIDC issuer's when checking expiry
+const clockSkew = time.Minute
+
+// oidcDiscovery is the part of the OIDC issuer's configuration the launcher uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider logs users in with an OpenID Connect issuer, using the authorization code flow
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	userClaim    string
+	groupsClaim  string
+	httpClient   *http.Client
+
+	// discovery and keys are fetched from the issuer when they are first needed, so the launcher still
+	// starts if the issuer can't be reached
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+	keys      *jose.JSONWebKeySet
+}
+
+// callbackURL is where the issuer sends users back to once they have logged in
+func (p *oidcProvider) callbackURL(r *http.Request) string {
+	if p.redirectURL != "" {
+		return p.redirectURL
+	}
+	return getAccountServiceURL(r) + authPath + "/callback"
+}
+
+// getJSON fetches a JSON document from the issuer
+func (p *oidcProvider) getJSON(documentURL string, v interface{}) error {
+	resp, err := p.httpClient.Get(documentURL)
+	if err != nil {
+		return &authentication.UpstreamError{URL: documentURL, Desc: "Failed to contact the OIDC issuer", From: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &authentication.UpstreamError{URL: documentURL, StatusCode: resp.StatusCode, Desc: fmt.Sprintf("OIDC issuer responded with %d", resp.StatusCode)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &authentication.UpstreamError{URL: documentURL, Desc: "Failed to read the OIDC issuer's response", From: err}
+	}
+	return nil
+}
+
+// getDiscovery returns the issuer's configuration, fetching it the first time
+func (p *oidcProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(p.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("OIDC issuer's configuration is for %q rather than %q", discovery.Issuer, p.issuer)
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// getKey returns the issuer's key with the ID, fetching the issuer's keys again if it is new
+func (p *oidcProvider) getKey(discovery *oidcDiscovery, kid string) (*jose.JSONWebKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if p.keys != nil {
+			if keys := p.keys.Key(kid); len(keys) > 0 {
+				return &keys[0], nil
+			}
+			if kid == "" && len(p.keys.Keys) == 1 {
+				return &p.keys.Keys[0], nil
+			}
+			if attempt > 0 {
+				break
+			}
+		}
+
+		var keys jose.JSONWebKeySet
+		if err := p.getJSON(discovery.JWKSURI, &keys); err != nil {
+			return nil, err
+		}
+		p.keys = &keys
+	}
+	return nil, fmt.Errorf("OIDC issuer has no key %q", kid)
+}
+
+// authURL is where a user logs in at the issuer
+func (p *oidcProvider) authURL(r *http.Request, state string, nonce string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.clientID)
+	query.Set("redirect_uri", p.callbackURL(r))
+	query.Set("scope", "openid email profile")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// exchange swaps the code the issuer sent the user back with for their ID token, returning its verified claims
+func (p *oidcProvider) exchange(r *http.Request, code string, nonce string) (map[string]interface{}, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.callbackURL(r)},
+		"client_id":    {p.clientID},
+	}
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, &authentication.UpstreamError{URL: discovery.TokenEndpoint, Desc: "Failed to contact the OIDC issuer", From: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1000))
+		return nil, &authentication.UpstreamError{URL: discovery.TokenEndpoint, StatusCode: resp.StatusCode, Desc: fmt.Sprintf("OIDC issuer refused the login (%d): %s", resp.StatusCode, body)}
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil || tokens.IDToken == "" {
+		return nil, &authentication.UpstreamError{URL: discovery.TokenEndpoint, Desc: "OIDC issuer didn't return an ID token", From: err}
+	}
+
+	return p.verify(discovery, tokens.IDToken, nonce)
+}
+
+// verify checks the ID token was signed by the issuer for the launcher, for this login, and hasn't expired
+func (p *oidcProvider) verify(discovery *oidcDiscovery, idToken string, nonce string) (map[string]interface{}, error) {
+	signed, err := jose.ParseSigned(idToken)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ID token: %w", err)
+	}
+	if len(signed.Signatures) != 1 {
+		return nil, fmt.Errorf("Invalid ID token: expected 1 signature but found %d", len(signed.Signatures))
+	}
+
+	key, err := p.getKey(discovery, signed.Signatures[0].Header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := signed.Verify(key)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ID token: %w", err)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("Invalid ID token: %w", err)
+	}
+
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("ID token was issued by %q rather than %q", issuer, p.issuer)
+	}
+	if !hasAudience(claims["aud"], p.clientID) {
+		return nil, fmt.Errorf("ID token isn't for client %q", p.clientID)
+	}
+	if expiry, ok := claims["exp"].(float64); !ok || time.Now().Add(-clockSkew).After(time.Unix(int64(expiry), 0)) {
+		return nil, fmt.Errorf("ID token has expired")
+	}
+	if claims["nonce"] != nonce {
+		return nil, fmt.Errorf("ID token is for another login")
+	}
+	return claims, nil
+}
+
+// hasAudience checks whether an aud claim, a string or a list, includes the client
+func hasAudience(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, audience := range aud {
+			if audience == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// oidcSession is the user logged in with OIDC and their groups, kept in their signed session cookie
+type oidcSession struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// claimList returns a claim which is a list, or a single value, as a list of strings
+func claimList(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var items []string
+		for _, item := range value {
+			if item, ok := item.(string); ok {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	return nil
+}
+
+// randomString returns a random value for a login's state and nonce
+func randomString() (string, error) {
+	value := make([]byte, 24)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(value), nil
+}
+
+// login sends the user to log in at the issuer, remembering the page they were after
+func (a *accessControl) login(w http.ResponseWriter, r *http.Request) {
+	state, err := randomString()
+	if err != nil {
+		deny(w, r, err)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		deny(w, r, err)
+		return
+	}
+
+	authURL, err := a.oidc.authURL(r, state, nonce)
+	if err != nil {
+		deny(w, r, err)
+		return
+	}
+
+	setCookie(w, r, loginCookie, a.logins.encode(strings.Join([]string{state, nonce, r.URL.RequestURI()}, " ")), int(loginLifetime.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// callbackHandler logs in the user the issuer sent back, and returns them to the page they were after
+func (a *accessControl) callbackHandler(w http.ResponseWriter, r *http.Request) {
+	if reason := r.FormValue("error"); reason != "" {
+		deny(w, r, &accessError{StatusCode: http.StatusUnauthorized, Desc: fmt.Sprintf("Login failed: %s %s", reason, r.FormValue("error_description"))})
+		return
+	}
+
+	var login []string
+	if cookie, err := r.Cookie(loginCookie); err == nil {
+		if value, err := a.logins.decode(cookie.Value); err == nil {
+			login = strings.SplitN(value, " ", 3)
+		}
+	}
+	if len(login) != 3 || r.FormValue("state") != login[0] {
+		deny(w, r, &accessError{StatusCode: http.StatusBadRequest, Desc: "Login has expired or is for another browser; try again"})
+		return
+	}
+	setCookie(w, r, loginCookie, "", -1)
+
+	claims, err := a.oidc.exchange(r, r.FormValue("code"), login[1])
+	if err != nil {
+		if _, upstream := err.(*authentication.UpstreamError); !upstream {
+			err = &accessError{StatusCode: http.StatusUnauthorized, Desc: "Login failed: " + err.Error()}
+		}
+		deny(w, r, err)
+		return
+	}
+
+	user := authentication.ClaimString(claims, a.oidc.userClaim)
+	if user == "" {
+		deny(w, r, &accessError{StatusCode: http.StatusUnauthorized, Desc: fmt.Sprintf("Login failed: ID token has no %s", a.oidc.userClaim)})
+		return
+	}
+	if err := a.allowed(user); err != nil {
+		deny(w, r, err)
+		return
+	}
+
+	session, err := json.Marshal(oidcSession{User: user, Groups: claimList(claims, a.oidc.groupsClaim)})
+	if err != nil {
+		deny(w, r, err)
+		return
+	}
+	setCookie(w, r, sessionCookie, a.sessions.encode(string(session)), int(a.sessions.lifetime.Seconds()))
+	log.Printf("%s logged in", user)
+
+	// Only return to the launcher's own pages
+	returnTo := login[2]
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		returnTo = "/"
+	}
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
+
+// logoutHandler ends the user's login to the launcher, though not to the issuer
+func (a *accessControl) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	setCookie(w, r, sessionCookie, "", -1)
+	w.Write([]byte("Logged out of the launcher"))
+}
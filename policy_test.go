@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/go-launch-a-survey/settings"
+)
+
+const testPolicy = `
+rules:
+  - groups: [testers]
+    roles: [dumper]
+    targets: ["*"]
+    actions: [launch]
+  - groups: [admins]
+    roles: ["*", flusher]
+    targets: ["*"]
+    actions: [launch, flush]
+  - groups: [launchers]
+    roles: ["*"]
+    targets: ["*"]
+    actions: [launch]
+`
+
+// writePolicy writes the policy to a temporary file, which the caller removes, returning its path
+func writePolicy(t *testing.T, policy string) string {
+	policyFile, err := ioutil.TempFile("", "policy-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create policy file: %s", err)
+	}
+	policyFile.WriteString(policy)
+	policyFile.Close()
+	return policyFile.Name()
+}
+
+// newPolicyHarness starts a launcher behind an auth proxy, checking its users against the policy
+func newPolicyHarness(t *testing.T, policy string) *harness {
+	path := writePolicy(t, policy)
+	defer os.Remove(path)
+
+	return newHarnessWithSettings(t, map[string]string{
+		"ACCESS_CONTROL":     "proxy",
+		"ACCESS_POLICY_PATH": path,
+	})
+}
+
+// as has the harness' requests come through the auth proxy from the user in the groups
+func (h *harness) as(user string, groups string) {
+	h.client.Transport = withHeaders{"X-Forwarded-User": user, "X-Forwarded-Groups": groups}
+}
+
+func TestPolicyLimitsTestersRoles(t *testing.T) {
+	h := newPolicyHarness(t, testPolicy)
+	defer h.Close()
+	h.as("tester@example.com", "testers")
+
+	// The tester can launch with the roles their group is allowed
+	claims := h.launchForm()
+	if roles, _ := claims["roles"].([]interface{}); len(roles) != 1 || roles[0] != "dumper" {
+		t.Errorf("Expected a token with the dumper role but recieved %v", claims["roles"])
+	}
+
+	// But not with the flusher role, or the flush button
+	withFlusher := launchValues()
+	withFlusher.Set("roles", "flusher")
+	withFlushButton := launchValues()
+	withFlushButton.Del("action_launch")
+	withFlushButton.Set("action_flush", "Flush Survey Data")
+	for _, values := range []url.Values{withFlusher, withFlushButton} {
+		resp := h.postForm("/", values)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected status 403 for a token the policy doesn't allow but recieved %d", resp.StatusCode)
+		}
+	}
+
+	resp := h.get("/quick-launch?roles=flusher&url=" + url.QueryEscape(h.runner.URL+"/schemas/1/0205"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a quick-launch with the flusher role but recieved %d", resp.StatusCode)
+	}
+
+	// Flushes through the API are refused too, without reaching runner
+	results := h.postFlush(`{"items": [{"schema": "1_0205.json"}]}`)
+	if len(results) != 1 || results[0].Flushed || !strings.Contains(results[0].Error, "isn't allowed to flush") {
+		t.Errorf("Expected the flush to be refused but recieved %+v", results)
+	}
+	if len(h.runnerFlushes()) != 0 {
+		t.Errorf("Expected runner not to be asked to flush but recieved %v", h.runnerFlushes())
+	}
+
+	// "*" allows any role but flusher, which would let the token flush at runner
+	h.as("launcher@example.com", "launchers")
+	resp = h.postForm("/", withFlusher)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status 403 for the flusher role from a rule allowing any role but recieved %d", resp.StatusCode)
+	}
+
+	// Users in no group the policy names can't launch at all
+	h.as("someone@example.com", "")
+	resp = h.postForm("/", launchValues())
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a user the policy doesn't name but recieved %d", resp.StatusCode)
+	}
+}
+
+func TestPolicyLetsAdminsFlush(t *testing.T) {
+	h := newPolicyHarness(t, testPolicy)
+	defer h.Close()
+	h.as("admin@example.com", "testers, admins")
+
+	h.launchForm()
+	resp := h.postForm("/flush", url.Values{"launch": {"1"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(h.runnerFlushes()) != 1 {
+		t.Errorf("Expected the admin's flush to be made but recieved %d", resp.StatusCode)
+	}
+}
+
+func TestPolicyUsesOIDCGroups(t *testing.T) {
+	issuer := newFakeIssuer(t, "admin@example.com")
+	defer issuer.Close()
+	issuer.groups = []string{"admins"}
+
+	path := writePolicy(t, testPolicy)
+	defer os.Remove(path)
+	h := newHarnessWithSettings(t, map[string]string{
+		"ACCESS_CONTROL":        "oidc",
+		"ACCESS_SESSION_SECRET": "test-secret",
+		"ACCESS_POLICY_PATH":    path,
+		"OIDC_ISSUER_URL":       issuer.URL,
+		"OIDC_CLIENT_ID":        "launcher",
+		"OIDC_CLIENT_SECRET":    "secret",
+	})
+	defer h.Close()
+	h.client.Jar, _ = cookiejar.New(nil)
+
+	// The groups the user logged in with are kept in their session
+	h.login("/")
+	withFlushButton := launchValues()
+	withFlushButton.Del("action_launch")
+	withFlushButton.Set("action_flush", "Flush Survey Data")
+	resp := h.postForm("/", withFlushButton)
+	defer resp.Body.Close()
+	claims := h.claims(h.tokenFromForm(resp, "/flush", false))
+	if claims["ru_name"] != "ACME" {
+		t.Errorf("Expected an admin's flush token but recieved %v", claims)
+	}
+}
+
+func TestPolicyMustNameConfiguredTargets(t *testing.T) {
+	path := writePolicy(t, `rules: [{groups: [testers], roles: [dumper], targets: [production], actions: [launch]}]`)
+	defer os.Remove(path)
+
+	config := settings.FromValues(map[string]string{
+		"ACCESS_POLICY_PATH":   path,
+		"JWT_SIGNING_KEY_PATH": launcherSigningKeyPath,
+	})
+	if l, err := newServerLauncher(config); err == nil || !strings.Contains(err.Error(), "production") {
+		if l != nil {
+			l.Close()
+		}
+		t.Errorf("Expected an error for a policy naming an unknown target but recieved %v", err)
+	}
+}
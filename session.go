@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+)
+
+// serverSideField is the launch form and quick-launch field which has the launcher start runner's session
+// itself, reporting runner's response rather than redirecting the browser to runner
+const serverSideField = "server_side"
+
+// startSession launches the claims into runner from the launcher and reports how runner responded, recording
+// the launch once runner has been sent its token
+func (l *launcher) startSession(w http.ResponseWriter, r *http.Request, t *target, claims map[string]interface{}, record launchRecord) {
+	session, err := t.authentication.StartSession(claims)
+	if err != nil {
+		l.writeError(w, r, err)
+		return
+	}
+	l.recordLaunch(r, record)
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, session)
+		return
+	}
+	serveTemplate("session.html", session, w, r)
+}
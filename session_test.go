@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// postServerSide launches the form values from the launcher, returning runner's session as JSON
+func (h *harness) postServerSide(values url.Values) authentication.RunnerSession {
+	values.Set(serverSideField, "true")
+	req := newFormRequest(h.launcher.URL, "/", values)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.t.Fatalf("POST / failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		h.t.Fatalf("Expected status 200 but recieved %d: %s", resp.StatusCode, body)
+	}
+	var session authentication.RunnerSession
+	json.NewDecoder(resp.Body).Decode(&session)
+	return session
+}
+
+func TestServerSideLaunchReportsRunnersSession(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	session := h.postServerSide(url.Values{
+		"schema":           {"1_0205.json"},
+		"ru_name":          {"ACME"},
+		"ref_p_start_date": {"2020-01-01"},
+		"action_launch":    {"Open Survey"},
+	})
+
+	if !session.Accepted || session.StatusCode != http.StatusFound || session.FinalStatusCode != http.StatusOK {
+		t.Errorf("Expected runner to accept the token but recieved %+v", session)
+	}
+	if session.Location != h.runner.URL+"/questionnaire/1/0205/" || session.URL != session.Location {
+		t.Errorf("Expected the questionnaire's URL but recieved %+v", session)
+	}
+	if len(session.Cookies) != 1 || session.Cookies[0].Name != "session" || session.Cookies[0].Value != session.TxID {
+		t.Errorf("Expected runner's session cookie but recieved %+v", session.Cookies)
+	}
+
+	sessions := h.runnerSessions()
+	if len(sessions) != 1 {
+		t.Fatalf("Expected runner to receive one session but recieved %d", len(sessions))
+	}
+	if _, ok := sessions[0][serverSideField]; ok {
+		t.Errorf("Expected %s to be left out of the token", serverSideField)
+	}
+	if n := h.runnerQueryTokens(); n != 0 {
+		t.Errorf("Expected the token to be POSTed in the form but recieved %d in the URL", n)
+	}
+}
+
+func TestServerSideLaunchReportsRunnersErrorPage(t *testing.T) {
+	// Tokens encrypted for the real runner's key can't be decrypted by the fake runner
+	h := newHarnessWithSettings(t, map[string]string{"JWT_ENCRYPTION_KEY_PATH": "jwt-test-keys/sdc-user-authentication-encryption-sr-public-key.pem"})
+	defer h.Close()
+
+	session := h.postServerSide(url.Values{
+		"schema":           {"1_0205.json"},
+		"ru_name":          {"ACME"},
+		"ref_p_start_date": {"2020-01-01"},
+		"action_launch":    {"Open Survey"},
+	})
+
+	if session.Accepted || session.StatusCode != http.StatusForbidden || !strings.HasPrefix(session.Error, "Sorry, there is a problem") {
+		t.Errorf("Expected runner's error page to be reported but recieved %+v", session)
+	}
+	if strings.Contains(session.URL, "token=") {
+		t.Errorf("Expected the token to be left out of the reported URL but recieved %s", session.URL)
+	}
+}
+
+func TestServerSideQuickLaunch(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	resp := h.get("/quick-launch?server_side=true&url=" + url.QueryEscape(h.runner.URL+"/schemas/1/0205"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "Runner accepted the token") {
+		t.Errorf("Expected the session page but recieved %d: %s", resp.StatusCode, body)
+	}
+	if len(h.runnerSessions()) != 1 {
+		t.Errorf("Expected runner to receive one session")
+	}
+}
+
+func TestServerSideLaunchIsOnlyRecordedOnceRunnerHasTheToken(t *testing.T) {
+	h := newHarnessWithSettings(t, map[string]string{"SURVEY_RUNNER_URL": "http://127.0.0.1:1"})
+	defer h.Close()
+
+	resp := h.get("/quick-launch?server_side=true&url=" + url.QueryEscape(h.runner.URL+"/schemas/1/0205"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected status 502 when runner can't be reached but recieved %d", resp.StatusCode)
+	}
+
+	var launches []historyEntry
+	h.getJSON("/history", &launches)
+	if len(launches) != 0 {
+		t.Errorf("Expected nothing to be recorded but recieved %+v", launches)
+	}
+}
@@ -1,30 +1,384 @@
 package settings
 
-import "os"
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-var _settings map[string]string
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
 
-func setSetting(key string, defaultValue string) {
-	if value, present := os.LookupEnv(key); present {
-		_settings[key] = value
-	} else {
-		_settings[key] = defaultValue
+// ConfigFileEnv is the environment variable holding the path of an optional settings file
+const ConfigFileEnv = "GO_LAUNCH_A_SURVEY_CONFIG_FILE"
+
+// Kind describes how a setting's value is interpreted and validated
+type Kind int
+
+// The kinds of setting
+const (
+	String Kind = iota
+	URL
+	Path
+	Int
+	Bool
+	Duration
+)
+
+// Source describes where the effective value of a setting came from
+type Source string
+
+// The sources a setting can be read from, in increasing order of precedence
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+)
+
+type definition struct {
+	name         string
+	kind         Kind
+	defaultValue string
+	// defaultFrom names another setting whose value is used as the default
+	defaultFrom string
+	secret      bool
+	// choices restricts the setting to one of a set of values
+	choices     []string
+	description string
+}
+
+var definitions = []definition{
+	{name: "GO_LAUNCH_A_SURVEY_LISTEN_HOST", kind: String, defaultValue: "0.0.0.0", description: "Host address to listen on"},
+	{name: "GO_LAUNCH_A_SURVEY_LISTEN_PORT", kind: Int, defaultValue: "8000", description: "Host port to listen on"},
+	{name: "SURVEY_RUNNER_URL", kind: URL, defaultValue: "http://localhost:5000", description: "URL of Survey Runner to re-direct to when launching a survey"},
+	{name: "SURVEY_RUNNER_SCHEMA_URL", kind: URL, defaultFrom: "SURVEY_RUNNER_URL", description: "URL of Survey Runner to load schemas from"},
+	{name: "RUNNER_TOKEN_DELIVERY", kind: String, defaultValue: "redirect", choices: []string{"redirect", "post"}, description: "How the browser takes tokens to runner: redirected with the token in the URL, or POSTing it in a form"},
+	{name: "SCHEMA_VALIDATOR_URL", kind: URL, description: "URL of the schema validator"},
+	{name: "SURVEY_REGISTER_URL", kind: URL, defaultValue: "http://localhost:8080", description: "URL of eq-survey-register to load schema list from"},
+	{name: "SCHEMA_LIST_LIFETIME", kind: Duration, defaultValue: "1m", description: "How long the list of schemas from runner and the register is kept before it is fetched again"},
+	{name: "SURVEY_REGISTER_VERSION_METHOD", kind: String, defaultValue: "GET", choices: []string{"GET", "POST"}, description: "HTTP method used to load a version of a schema from the register"},
+	{name: "JWT_ENCRYPTION_KEY_PATH", kind: Path, defaultValue: "jwt-test-keys/sdc-user-authentication-encryption-sr-public-key.pem", description: "Path to the JWT Encryption Key (PEM format)"},
+	{name: "JWT_SIGNING_KEY_PATH", kind: Path, defaultValue: "jwt-test-keys/sdc-user-authentication-signing-launcher-private-key.pem", description: "Path to the JWT Signing Key (PEM format)"},
+	{name: "DEFAULTS_CONFIG_PATH", kind: Path, description: "Path to a JSON file of per-survey default metadata values"},
+	{name: "HTTP_CLIENT_TIMEOUT", kind: Duration, defaultValue: "5s", description: "Timeout for requests to runner, the register and the schema validator"},
+	{name: "CONFIG_VIEW_ENABLED", kind: Bool, defaultValue: "false", description: "Whether the effective settings are shown at /config"},
+	{name: "RUNNER_TARGETS_PATH", kind: Path, description: "Path to a file of named runner targets to launch into"},
+	{name: "ACCOUNT_SERVICE_PATH", kind: String, defaultValue: "/account-service", description: "Path of the mock account service's survey list, which runner links back to"},
+	{name: "ACCOUNT_SERVICE_LOG_OUT_PATH", kind: String, defaultValue: "/account-service/signed-out", description: "Path of the mock account service's signed out page, which runner redirects to on sign out"},
+	{name: "MOCK_RUNNER_ENABLED", kind: Bool, defaultValue: "false", description: "Whether to serve a mock Survey Runner at /mock-runner and launch into it"},
+	{name: "MOCK_RUNNER_URL", kind: URL, description: "URL browsers reach the mock runner at, such as https://launcher.example.com/mock-runner; taken from GO_LAUNCH_A_SURVEY_LISTEN_PORT on localhost if not set"},
+	{name: "MOCK_RUNNER_SCHEMAS_PATH", kind: Path, description: "Path to the directory of schemas served by the mock runner, instead of its samples"},
+	{name: "MOCK_RUNNER_DECRYPTION_KEY_PATH", kind: Path, description: "Path to runner's private key (PEM format) used by the mock runner; a key pair is generated if not set"},
+	{name: "LAUNCH_HISTORY_SIZE", kind: Int, defaultValue: "100", description: "Number of recent launches, and of submissions, kept in memory for the launch history"},
+	{name: "SUBMISSION_SINK_ENABLED", kind: Bool, defaultValue: "false", description: "Whether to accept runner's downstream submissions at /submissions and show them in the launch history"},
+	{name: "SUBMISSION_SINK_TOKEN", kind: String, secret: true, description: "Secret runner must send as a bearer token with its submissions; required when SUBMISSION_SINK_ENABLED is true"},
+	{name: "SUBMISSION_DECRYPTION_KEY_PATH", kind: Path, description: "Path to the private key (PEM format) which decrypts runner's submissions when they are encrypted"},
+	{name: "SUBMISSION_VERIFICATION_KEY_PATH", kind: Path, description: "Path to runner's public key (PEM format) which verifies the signature of submissions"},
+	{name: "ACCESS_CONTROL", kind: String, defaultValue: "none", choices: []string{"none", "basic", "oidc", "proxy"}, description: "How users of the launcher are authenticated: not at all, with basic auth, by logging in with OIDC, or by a header set by an auth proxy"},
+	{name: "ACCESS_ALLOWED_USERS", kind: String, description: "Comma separated users allowed to use the launcher; any authenticated user is allowed if not set"},
+	{name: "ACCESS_SESSION_SECRET", kind: String, secret: true, description: "Key signing the session cookies of users logged in with OIDC; a key is generated on startup if not set"},
+	{name: "ACCESS_SESSION_LIFETIME", kind: Duration, defaultValue: "8h", description: "How long users logged in with OIDC stay logged in"},
+	{name: "BASIC_AUTH_USERNAME", kind: String, description: "Username users must give when ACCESS_CONTROL is basic"},
+	{name: "BASIC_AUTH_PASSWORD", kind: String, secret: true, description: "Password users must give when ACCESS_CONTROL is basic"},
+	{name: "OIDC_ISSUER_URL", kind: URL, description: "URL of the OpenID Connect issuer users log in with when ACCESS_CONTROL is oidc"},
+	{name: "OIDC_CLIENT_ID", kind: String, description: "Client ID of the launcher registered with the OIDC issuer"},
+	{name: "OIDC_CLIENT_SECRET", kind: String, secret: true, description: "Client secret of the launcher registered with the OIDC issuer"},
+	{name: "OIDC_REDIRECT_URL", kind: URL, description: "URL of the launcher's /auth/callback registered with the OIDC issuer; taken from the request if not set"},
+	{name: "OIDC_USER_CLAIM", kind: String, defaultValue: "email", description: "Claim of the OIDC ID token which names the user"},
+	{name: "OIDC_GROUPS_CLAIM", kind: String, defaultValue: "groups", description: "Claim of the OIDC ID token which lists the user's groups, for ACCESS_POLICY_PATH"},
+	{name: "AUTH_PROXY_USER_HEADER", kind: String, defaultValue: "X-Forwarded-User", description: "Header naming the user, set by the auth proxy in front of the launcher when ACCESS_CONTROL is proxy"},
+	{name: "AUTH_PROXY_GROUPS_HEADER", kind: String, defaultValue: "X-Forwarded-Groups", description: "Header listing the user's groups, comma separated, set by the auth proxy when ACCESS_CONTROL is proxy"},
+	{name: "ACCESS_POLICY_PATH", kind: Path, description: "Path to a file of rules saying which roles, runner targets and actions each user or group may use; everything is allowed if not set"},
+	{name: "API_KEY", kind: String, secret: true, description: "Key API clients give as a bearer token instead of authenticating as ACCESS_CONTROL says; their requests need no CSRF token"},
+}
+
+type value struct {
+	value  string
+	source Source
+}
+
+// Config holds the effective value of every setting. Each Config is independent, so several
+// configurations can be used side by side in one process.
+type Config struct {
+	values     map[string]value
+	loadErrors []error
+}
+
+func lookupDefinition(name string) (definition, bool) {
+	for _, d := range definitions {
+		if d.name == name {
+			return d, true
+		}
+	}
+	return definition{}, false
+}
+
+func readFile(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings file %s: %w", path, err)
+	}
+
+	values := make(map[string]interface{})
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		// JSON is a subset of YAML
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		_, err = toml.Decode(string(data), &values)
+	default:
+		err = fmt.Errorf("unsupported file extension %q; expected .yaml, .yml, .toml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse settings file %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+func newConfig(overrides map[string]string, source Source, useEnv bool) *Config {
+	c := &Config{values: make(map[string]value)}
+
+	for name := range overrides {
+		if _, ok := lookupDefinition(name); !ok {
+			c.loadErrors = append(c.loadErrors, fmt.Errorf("unknown setting %s", name))
+		}
+	}
+
+	for _, d := range definitions {
+		current := value{value: d.defaultValue, source: SourceDefault}
+		if d.defaultFrom != "" {
+			current.value = c.values[d.defaultFrom].value
+		}
+		if v, ok := overrides[d.name]; ok {
+			current = value{value: v, source: source}
+		}
+		if useEnv {
+			if env, present := os.LookupEnv(d.name); present {
+				current = value{value: env, source: SourceEnv}
+			}
+		}
+		c.values[d.name] = current
+	}
+
+	return c
+}
+
+// Load reads the defaults, then the values from the settings file, if a path is given, and
+// finally the environment. Any problems reading the file are returned by Validate.
+func Load(path string) *Config {
+	var loadErrors []error
+	fileValues := make(map[string]string)
+
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			loadErrors = append(loadErrors, err)
+		}
+		for name, v := range values {
+			fileValues[strings.ToUpper(name)] = fmt.Sprint(v)
+		}
+	}
+
+	c := newConfig(fileValues, SourceFile, true)
+	c.loadErrors = append(loadErrors, c.loadErrors...)
+	return c
+}
+
+// FromEnvironment loads the settings from the environment and the file named by ConfigFileEnv
+func FromEnvironment() *Config {
+	return Load(os.Getenv(ConfigFileEnv))
+}
+
+// FromValues creates settings from the defaults and the given values, ignoring the environment.
+// It is intended for tests and tools which need an isolated configuration.
+func FromValues(values map[string]string) *Config {
+	return newConfig(values, SourceFile, false)
+}
+
+// Get returns the value for the specified named setting. It panics if the setting is not defined.
+func (c *Config) Get(name string) string {
+	v, ok := c.values[name]
+	if !ok {
+		panic("settings: unknown setting " + name)
 	}
+	return v.value
 }
 
-func init() {
-	_settings = make(map[string]string)
-	setSetting("GO_LAUNCH_A_SURVEY_LISTEN_HOST", "0.0.0.0")
-	setSetting("GO_LAUNCH_A_SURVEY_LISTEN_PORT", "8000")
-	setSetting("SURVEY_RUNNER_URL", "http://localhost:5000")
-	setSetting("SURVEY_RUNNER_SCHEMA_URL", Get("SURVEY_RUNNER_URL"))
-	setSetting("SCHEMA_VALIDATOR_URL", "")
-	setSetting("SURVEY_REGISTER_URL", "http://localhost:8080")
-	setSetting("JWT_ENCRYPTION_KEY_PATH", "jwt-test-keys/sdc-user-authentication-encryption-sr-public-key.pem")
-	setSetting("JWT_SIGNING_KEY_PATH", "jwt-test-keys/sdc-user-authentication-signing-launcher-private-key.pem")
+// Source returns where the value of the named setting came from. It panics if the setting is not defined.
+func (c *Config) Source(name string) Source {
+	v, ok := c.values[name]
+	if !ok {
+		panic("settings: unknown setting " + name)
+	}
+	return v.source
+}
+
+// GetURL returns the named setting parsed as a URL, or nil if it is not set
+func (c *Config) GetURL(name string) (*url.URL, error) {
+	raw := c.Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid URL: %w", name, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("%s is not a valid URL: %q must include a scheme and host", name, raw)
+	}
+	return u, nil
+}
+
+// GetInt returns the named setting parsed as an integer
+func (c *Config) GetInt(name string) (int, error) {
+	i, err := strconv.Atoi(c.Get(name))
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid integer: %q", name, c.Get(name))
+	}
+	return i, nil
+}
+
+// GetBool returns the named setting parsed as a boolean
+func (c *Config) GetBool(name string) (bool, error) {
+	b, err := strconv.ParseBool(c.Get(name))
+	if err != nil {
+		return false, fmt.Errorf("%s is not a valid boolean: %q", name, c.Get(name))
+	}
+	return b, nil
+}
+
+// GetDuration returns the named setting parsed as a duration such as "5s" or "10m"
+func (c *Config) GetDuration(name string) (time.Duration, error) {
+	d, err := time.ParseDuration(c.Get(name))
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid duration: %q", name, c.Get(name))
+	}
+	return d, nil
+}
+
+func (c *Config) validatePath(name string) error {
+	path := c.Get(name)
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s cannot be read: %w", name, err)
+	}
+	file.Close()
+	return nil
+}
+
+// Validate checks every setting, including those of each runner target, can be interpreted
+// as its kind, returning all the problems found
+func (c *Config) Validate() []error {
+	errs := c.validateSettings()
+
+	if c.Get("RUNNER_TARGETS_PATH") != "" && c.validatePath("RUNNER_TARGETS_PATH") == nil {
+		targets, err := c.Targets()
+		if err != nil {
+			return append(errs, err)
+		}
+		// Only report the problems a target introduces, not those it shares with this config
+		reported := make(map[string]bool)
+		for _, err := range errs {
+			reported[err.Error()] = true
+		}
+		for _, target := range targets {
+			for _, err := range target.Config.validateSettings() {
+				if !reported[err.Error()] {
+					errs = append(errs, fmt.Errorf("target %s: %w", target.Name, err))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+func (c *Config) validateSettings() []error {
+	errs := append([]error{}, c.loadErrors...)
+
+	for _, d := range definitions {
+		var err error
+		switch d.kind {
+		case URL:
+			_, err = c.GetURL(d.name)
+		case Path:
+			err = c.validatePath(d.name)
+		case Int:
+			_, err = c.GetInt(d.name)
+		case Bool:
+			_, err = c.GetBool(d.name)
+		case Duration:
+			_, err = c.GetDuration(d.name)
+		}
+		if err == nil && len(d.choices) > 0 {
+			err = c.validateChoice(d)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+func (c *Config) validateChoice(d definition) error {
+	for _, choice := range d.choices {
+		if c.Get(d.name) == choice {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must be one of %s: %q", d.name, strings.Join(d.choices, ", "), c.Get(d.name))
+}
+
+// Effective describes the value of a setting for display, with secrets masked
+type Effective struct {
+	Name        string
+	Value       string
+	Source      Source
+	Description string
+}
+
+func mask(d definition, v string) string {
+	if v == "" {
+		return v
+	}
+	if d.secret {
+		return "********"
+	}
+	if d.kind == URL {
+		if u, err := url.Parse(v); err == nil && u.User != nil {
+			if _, hasPassword := u.User.Password(); hasPassword {
+				u.User = url.UserPassword(u.User.Username(), "********")
+				return u.String()
+			}
+		}
+	}
+	return v
 }
 
-// Get returns the value for the specified named setting
-func Get(name string) string {
-	return _settings[name]
+// All returns the effective value of every setting, sorted by name, with secrets masked
+func (c *Config) All() []Effective {
+	all := make([]Effective, 0, len(definitions))
+	for _, d := range definitions {
+		v := c.values[d.name]
+		all = append(all, Effective{
+			Name:        d.name,
+			Value:       mask(d, v.value),
+			Source:      v.source,
+			Description: d.description,
+		})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
 }
@@ -0,0 +1,108 @@
+package settings
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// SourceTarget is the source of settings overridden by a runner target
+const SourceTarget Source = "target"
+
+// DefaultTargetName is the name of the only target when no targets file is configured
+const DefaultTargetName = "default"
+
+// Target is a named environment the launcher can launch surveys into, such as a local or
+// dev runner, with its own runner, schema and register URLs and keys
+type Target struct {
+	Name   string
+	Config *Config
+}
+
+type targetsFile struct {
+	Targets []struct {
+		Name     string            `yaml:"name" toml:"name"`
+		Settings map[string]string `yaml:"settings" toml:"settings"`
+	} `yaml:"targets" toml:"targets"`
+}
+
+// With returns a copy of the config with the given settings overridden
+func (c *Config) With(overrides map[string]string, source Source) *Config {
+	copied := &Config{values: make(map[string]value), loadErrors: c.loadErrors}
+	for name, v := range c.values {
+		copied.values[name] = v
+	}
+
+	for name, v := range overrides {
+		if _, ok := lookupDefinition(name); !ok {
+			copied.loadErrors = append(copied.loadErrors, fmt.Errorf("unknown setting %s", name))
+			continue
+		}
+		copied.values[name] = value{value: v, source: source}
+	}
+
+	// Settings which default to another setting follow it unless they were set themselves
+	for _, d := range definitions {
+		if _, overridden := overrides[d.name]; d.defaultFrom != "" && !overridden && copied.values[d.name].source == SourceDefault {
+			copied.values[d.name] = value{value: copied.values[d.defaultFrom].value, source: SourceDefault}
+		}
+	}
+
+	return copied
+}
+
+// Targets returns the runner targets from the file named by RUNNER_TARGETS_PATH, each layered
+// over this config. Without a targets file the config itself is the only target.
+func (c *Config) Targets() ([]Target, error) {
+	path := c.Get("RUNNER_TARGETS_PATH")
+	if path == "" {
+		return []Target{{Name: DefaultTargetName, Config: c}}, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read targets file %s: %w", path, err)
+	}
+
+	var file targetsFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		err = yaml.Unmarshal(data, &file)
+	case ".toml":
+		_, err = toml.Decode(string(data), &file)
+	default:
+		err = fmt.Errorf("unsupported file extension %q; expected .yaml, .yml, .toml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse targets file %s: %w", path, err)
+	}
+
+	if len(file.Targets) == 0 {
+		return nil, fmt.Errorf("targets file %s does not define any targets", path)
+	}
+
+	targets := make([]Target, 0, len(file.Targets))
+	names := make(map[string]bool)
+	for i, t := range file.Targets {
+		if t.Name == "" {
+			return nil, fmt.Errorf("targets[%d] in %s has no name", i, path)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("target %s is defined more than once in %s", t.Name, path)
+		}
+		names[t.Name] = true
+
+		overrides := make(map[string]string)
+		for name, v := range t.Settings {
+			overrides[strings.ToUpper(name)] = v
+		}
+
+		targets = append(targets, Target{Name: t.Name, Config: c.With(overrides, SourceTarget)})
+	}
+
+	return targets, nil
+}
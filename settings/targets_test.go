@@ -0,0 +1,86 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTargetsDefaultsToASingleTarget(t *testing.T) {
+	config := FromValues(nil)
+
+	targets, err := config.Targets()
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if len(targets) != 1 || targets[0].Name != DefaultTargetName || targets[0].Config != config {
+		t.Errorf("Expected the config itself as the only target but recieved %v", targets)
+	}
+}
+
+func TestTargetsAreLayeredOverTheConfig(t *testing.T) {
+	path := writeSettingsFile(t, "targets.yaml", `targets:
+  - name: local
+    settings:
+      SURVEY_RUNNER_URL: http://localhost:5000
+  - name: dev
+    settings:
+      survey_runner_url: http://runner.dev:5000
+      SURVEY_RUNNER_SCHEMA_URL: http://schemas.dev:5000
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config := FromValues(map[string]string{"RUNNER_TARGETS_PATH": path, "SURVEY_REGISTER_URL": "http://register:8080"})
+
+	targets, err := config.Targets()
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if len(targets) != 2 || targets[0].Name != "local" || targets[1].Name != "dev" {
+		t.Fatalf("Expected the local and dev targets in order but recieved %v", targets)
+	}
+
+	local := targets[0].Config
+	if local.Get("SURVEY_RUNNER_SCHEMA_URL") != "http://localhost:5000" {
+		t.Errorf("Expected SURVEY_RUNNER_SCHEMA_URL to follow the target's SURVEY_RUNNER_URL but recieved %s", local.Get("SURVEY_RUNNER_SCHEMA_URL"))
+	}
+	if local.Get("SURVEY_REGISTER_URL") != "http://register:8080" {
+		t.Errorf("Expected SURVEY_REGISTER_URL from the base config but recieved %s", local.Get("SURVEY_REGISTER_URL"))
+	}
+
+	dev := targets[1].Config
+	if dev.Get("SURVEY_RUNNER_URL") != "http://runner.dev:5000" || dev.Get("SURVEY_RUNNER_SCHEMA_URL") != "http://schemas.dev:5000" {
+		t.Errorf("Expected the dev target's URLs but recieved %s and %s", dev.Get("SURVEY_RUNNER_URL"), dev.Get("SURVEY_RUNNER_SCHEMA_URL"))
+	}
+
+	if config.Get("SURVEY_RUNNER_URL") != "http://localhost:5000" {
+		t.Errorf("Expected the base config to be unchanged but recieved %s", config.Get("SURVEY_RUNNER_URL"))
+	}
+}
+
+func TestValidateReportsInvalidTargets(t *testing.T) {
+	path := writeSettingsFile(t, "targets.toml", `[[targets]]
+name = "broken"
+[targets.settings]
+SURVEY_RUNNER_URL = "not a url"
+NOT_A_SETTING = "x"
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config := FromValues(map[string]string{"RUNNER_TARGETS_PATH": path, "JWT_ENCRYPTION_KEY_PATH": "", "JWT_SIGNING_KEY_PATH": ""})
+
+	// The target's SURVEY_RUNNER_SCHEMA_URL defaults to its invalid SURVEY_RUNNER_URL
+	errs := config.Validate()
+	if len(errs) != 3 {
+		t.Errorf("Expected errors for the target's URLs and unknown setting but recieved %v", errs)
+	}
+}
+
+func TestTargetsRejectsDuplicateNames(t *testing.T) {
+	path := writeSettingsFile(t, "targets.json", `{"targets": [{"name": "local"}, {"name": "local"}]}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if _, err := FromValues(map[string]string{"RUNNER_TARGETS_PATH": path}).Targets(); err == nil {
+		t.Errorf("Expected an error for the duplicate target name")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/subtle"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/go-launch-a-survey/authentication"
+	"github.com/ONSdigital/go-launch-a-survey/settings"
+	"github.com/gorilla/mux"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// submissionsPath is where the submission sink receives runner's submissions
+const submissionsPath = "/submissions"
+
+// maxSubmissionSize limits the payloads the sink reads
+const maxSubmissionSize = 1 << 20
+
+// maxSubmissions limits how many submissions are kept in memory, however big LAUNCH_HISTORY_SIZE is
+const maxSubmissions = 100
+
+// errSubmissionToken is returned for a submission without SUBMISSION_SINK_TOKEN, which isn't from runner
+var errSubmissionToken = &accessError{StatusCode: http.StatusUnauthorized, Desc: "Invalid or missing submission sink token"}
+
+// submission is a payload runner sent downstream, which would otherwise go on the submission queue
+type submission struct {
+	ID       int       `json:"id"`
+	Received time.Time `json:"received"`
+
+	// Encrypted is set if the payload was a JWE, and Verified if its signature was verified
+	Encrypted bool   `json:"encrypted"`
+	Verified  bool   `json:"verified"`
+	Error     string `json:"error,omitempty"`
+
+	TxID       string `json:"tx_id,omitempty"`
+	ResponseID string `json:"response_id,omitempty"`
+	CaseID     string `json:"case_id,omitempty"`
+	RURef      string `json:"ru_ref,omitempty"`
+
+	// LaunchID is the launch in the history the submission is for, if it was found
+	LaunchID int `json:"launch_id,omitempty"`
+
+	// payload is the decrypted submission, or what was received if it couldn't be decrypted
+	payload []byte
+}
+
+// submissionSummary is how a submission is listed against its launch
+type submissionSummary struct {
+	ID       int       `json:"id"`
+	Received time.Time `json:"received"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// MarshalJSON includes the payload as JSON if it is, or as a string if it isn't
+func (s submission) MarshalJSON() ([]byte, error) {
+	type plain submission
+	var payload interface{} = string(s.payload)
+	var decoded interface{}
+	if json.Unmarshal(s.payload, &decoded) == nil {
+		payload = decoded
+	}
+	return json.Marshal(struct {
+		plain
+		Payload interface{} `json:"payload"`
+	}{plain(s), payload})
+}
+
+// PrettyPayload is the payload indented for display, if it is JSON
+func (s submission) PrettyPayload() string {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, s.payload, "", "  "); err != nil {
+		return string(s.payload)
+	}
+	return indented.String()
+}
+
+// submissionSink stands in for the submission queue, keeping the most recent submissions in memory
+type submissionSink struct {
+	// token is the SUBMISSION_SINK_TOKEN runner sends as a bearer token, as anyone can reach the sink
+	token string
+
+	decryptionKey   *rsa.PrivateKey
+	verificationKey *rsa.PublicKey
+	history         *launchHistory
+
+	mutex       sync.Mutex
+	size        int
+	lastID      int
+	submissions []submission
+}
+
+// newSubmissionSink creates the sink, linking submissions to launches in the history
+func newSubmissionSink(config *settings.Config, history *launchHistory) (*submissionSink, error) {
+	size, err := config.GetInt("LAUNCH_HISTORY_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	if size > maxSubmissions {
+		size = maxSubmissions
+	}
+	s := &submissionSink{size: size, history: history, token: config.Get("SUBMISSION_SINK_TOKEN")}
+	if s.token == "" {
+		return nil, errors.New("SUBMISSION_SINK_TOKEN must be set when SUBMISSION_SINK_ENABLED is true")
+	}
+
+	if path := config.Get("SUBMISSION_DECRYPTION_KEY_PATH"); path != "" {
+		if s.decryptionKey, err = authentication.LoadDecryptionKey(path); err != nil {
+			return nil, err
+		}
+	}
+	if path := config.Get("SUBMISSION_VERIFICATION_KEY_PATH"); path != "" {
+		if s.verificationKey, err = authentication.LoadVerificationKey(path); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// receive decrypts the payload if it is encrypted and stores it. A payload which can't be decrypted is
+// still stored, with the error.
+func (s *submissionSink) receive(payload []byte) submission {
+	received := submission{Received: time.Now(), payload: payload}
+
+	if authentication.IsCompactJOSE(string(payload)) {
+		received.Encrypted = true
+		plaintext, verified, err := authentication.DecryptPayload(string(payload), s.decryptionKey, s.verificationKey)
+		if err != nil {
+			log.Println("WARN: Failed to decrypt submission:", err)
+			received.Error = err.Error()
+		} else {
+			received.payload = plaintext
+			received.Verified = verified
+		}
+	}
+
+	if received.Error == "" {
+		var fields struct {
+			TxID       string `json:"tx_id"`
+			ResponseID string `json:"response_id"`
+			CaseID     string `json:"case_id"`
+			Metadata   struct {
+				RURef string `json:"ru_ref"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(received.payload, &fields); err != nil {
+			received.Error = "Submission is not JSON: " + err.Error()
+		}
+		received.TxID = fields.TxID
+		received.ResponseID = fields.ResponseID
+		received.CaseID = fields.CaseID
+		received.RURef = fields.Metadata.RURef
+	}
+
+	if launch, ok := s.history.find(received.TxID, received.ResponseID); ok {
+		received.LaunchID = launch.ID
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastID++
+	received.ID = s.lastID
+	if s.size > 0 {
+		s.submissions = append(s.submissions, received)
+		if len(s.submissions) > s.size {
+			s.submissions = s.submissions[len(s.submissions)-s.size:]
+		}
+	}
+	return received
+}
+
+// recent returns the stored submissions, newest first
+func (s *submissionSink) recent() []submission {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	submissions := make([]submission, len(s.submissions))
+	for i, received := range s.submissions {
+		submissions[len(s.submissions)-1-i] = received
+	}
+	return submissions
+}
+
+// get returns the stored submission with the ID
+func (s *submissionSink) get(id int) (submission, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, received := range s.submissions {
+		if received.ID == id {
+			return received, true
+		}
+	}
+	return submission{}, false
+}
+
+// forLaunch lists the stored submissions for the launch, oldest first
+func (s *submissionSink) forLaunch(launchID int) []submissionSummary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var summaries []submissionSummary
+	for _, received := range s.submissions {
+		if received.LaunchID == launchID {
+			summaries = append(summaries, submissionSummary{ID: received.ID, Received: received.Received, Error: received.Error})
+		}
+	}
+	return summaries
+}
+
+func (s *submissionSink) register(r *mux.Router) {
+	r.HandleFunc(submissionsPath, s.postSubmissionHandler).Methods("POST")
+	r.HandleFunc(submissionsPath, s.getSubmissionsHandler).Methods("GET")
+	r.HandleFunc(submissionsPath+"/{id:[0-9]+}", s.getSubmissionHandler).Methods("GET")
+}
+
+// postSubmissionHandler receives a submission from runner, in place of the submission queue
+func (s *submissionSink) postSubmissionHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		deny(w, r, errSubmissionToken)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err != nil {
+		http.Error(w, "Failed to read submission: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	received := s.receive(payload)
+	log.Printf("Submission %d received for tx_id %q", received.ID, received.TxID)
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":        received.ID,
+		"launch_id": received.LaunchID,
+		"error":     received.Error,
+	})
+}
+
+type submissionsPage struct {
+	Submissions []submission
+}
+
+func (s *submissionSink) getSubmissionsHandler(w http.ResponseWriter, r *http.Request) {
+	submissions := s.recent()
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, submissions)
+		return
+	}
+	serveTemplate("submissions.html", submissionsPage{Submissions: submissions}, w, r)
+}
+
+// submissionPage shows a submission with the launch it is for, if it is still in the history
+type submissionPage struct {
+	submission
+	Launch *launchRecord
+}
+
+func (s *submissionSink) getSubmissionHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	received, ok := s.get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, received)
+		return
+	}
+
+	p := submissionPage{submission: received}
+	if launch, ok := s.history.find(received.TxID, received.ResponseID); ok {
+		p.Launch = &launch
+	}
+	serveTemplate("submission.html", p, w, r)
+}
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
+	"sync"
 	"time"
 
 	"fmt"
@@ -14,24 +16,70 @@ import (
 	"strings"
 
 	"github.com/AreaHQ/jsonhal"
-	"github.com/ONSdigital/go-launch-a-survey/clients"
 	"github.com/ONSdigital/go-launch-a-survey/settings"
 )
 
-// ReqVersionBodyParams is a representation of the body params for the request
+// Service loads the schemas available from runner and the survey register, keeping the list for
+// SCHEMA_LIST_LIFETIME so each launch doesn't fetch it again
+type Service struct {
+	config     *settings.Config
+	httpClient *http.Client
+
+	schemasMutex  sync.Mutex
+	schemas       *LauncherSchemas
+	schemasExpiry time.Time
+}
+
+// NewService creates a Service which uses the URLs in the config
+func NewService(config *settings.Config, httpClient *http.Client) *Service {
+	return &Service{
+		config:     config,
+		httpClient: httpClient,
+	}
+}
+
+// ReqVersionBodyParams is a representation of the body params for the request to the register
+// for a version of a schema
 type ReqVersionBodyParams struct {
-	SurveyID      string
-	FormType      string
-	SurveyVersion string
+	SurveyID      string `json:"survey_id"`
+	FormType      string `json:"form_type"`
+	SurveyVersion string `json:"survey_version"`
 }
 
-// LauncherSchema is a representation of a schema in the Launcher
+// LauncherSchema is a representation of a schema in the Launcher. ID is stable across launches
+// and is used to find the schema again, whereas Name is only for display.
 type LauncherSchema struct {
+	ID         string
 	Name       string
 	EqID       string
 	FormType   string
 	URL        string
 	BodyParams ReqVersionBodyParams
+
+	// Register schemas are published versions of a survey
+	SurveyID      string
+	SurveyVersion string
+	Title         string
+	Published     time.Time
+	RegistryID    string
+}
+
+// RegisterSurvey is a survey from the eq-survey-register with each of its published versions, newest first
+type RegisterSurvey struct {
+	Key      string
+	SurveyID string
+	FormType string
+	Title    string
+	Versions []LauncherSchema
+}
+
+// NotFoundError is returned when no available schema has the requested ID
+type NotFoundError struct {
+	ID string
+}
+
+func (e *NotFoundError) Error() string {
+	return "Survey not found: " + e.ID
 }
 
 // LauncherSchemas is a separation of Test and Live schemas
@@ -44,6 +92,39 @@ type LauncherSchemas struct {
 	Other    []LauncherSchema
 }
 
+// RegisterSurveys groups the register schemas by survey and form type for the survey and version pickers
+func (l LauncherSchemas) RegisterSurveys() []RegisterSurvey {
+	var registerSurveys []RegisterSurvey
+	index := make(map[string]int)
+
+	for _, launcherSchema := range l.Register {
+		key := launcherSchema.SurveyID + "_" + launcherSchema.FormType
+		i, ok := index[key]
+		if !ok {
+			i = len(registerSurveys)
+			index[key] = i
+			registerSurveys = append(registerSurveys, RegisterSurvey{
+				Key:      key,
+				SurveyID: launcherSchema.SurveyID,
+				FormType: launcherSchema.FormType,
+				Title:    launcherSchema.Title,
+			})
+		}
+		registerSurveys[i].Versions = append(registerSurveys[i].Versions, launcherSchema)
+	}
+
+	return registerSurveys
+}
+
+// All returns every schema, in the order they are listed on the launch page
+func (l LauncherSchemas) All() []LauncherSchema {
+	var all []LauncherSchema
+	for _, group := range [][]LauncherSchema{l.Business, l.Census, l.Social, l.Test, l.Register, l.Other} {
+		all = append(all, group...)
+	}
+	return all
+}
+
 // RegisterResponse is the response from the eq-survey-register request
 type RegisterResponse struct {
 	FormType      string `json:"form_type"`
@@ -79,22 +160,41 @@ func extractEqIDFormType(schema string) (EqID, formType string) {
 func LauncherSchemaFromFilename(filename string) LauncherSchema {
 	EqID, formType := extractEqIDFormType(filename)
 	return LauncherSchema{
+		ID:       filename,
 		Name:     filename,
 		EqID:     EqID,
 		FormType: formType,
 	}
 }
 
-// GetAvailableSchemas Gets the list of static schemas an joins them with any schemas from the eq-survey-register if defined
-func GetAvailableSchemas() LauncherSchemas {
+// GetAvailableSchemas Gets the list of static schemas an joins them with any schemas from the eq-survey-register if defined.
+// The list is cached for SCHEMA_LIST_LIFETIME, unless runner or the register couldn't be reached.
+func (s *Service) GetAvailableSchemas() LauncherSchemas {
+	s.schemasMutex.Lock()
+	defer s.schemasMutex.Unlock()
+
+	if s.schemas != nil && time.Now().Before(s.schemasExpiry) {
+		return *s.schemas
+	}
+
+	schemaList, complete := s.loadAvailableSchemas()
+	if lifetime, _ := s.config.GetDuration("SCHEMA_LIST_LIFETIME"); complete && lifetime > 0 {
+		s.schemas = &schemaList
+		s.schemasExpiry = time.Now().Add(lifetime)
+	}
+	return schemaList
+}
 
-	client := clients.GetHTTPClient()
+// loadAvailableSchemas fetches the schema list, reporting whether every repository could be reached
+func (s *Service) loadAvailableSchemas() (LauncherSchemas, bool) {
 
 	schemaList := LauncherSchemas{}
+	complete := true
 
-	runnerSchemas, err := getAvailableSchemasFromRunner(client)
+	runnerSchemas, err := s.getAvailableSchemasFromRunner()
 	if err != nil {
 		log.Printf(`WARN: Unexpected error whilst retrieving schemas from EQRunner; skipping repository`)
+		complete = false
 	} else {
 		for _, launcherSchema := range runnerSchemas {
 			if strings.HasPrefix(launcherSchema.Name, "test_") {
@@ -109,19 +209,20 @@ func GetAvailableSchemas() LauncherSchemas {
 		}
 	}
 
-	schemaList.Register, err = GetAvailableSchemasFromRegister(client)
+	schemaList.Register, err = s.GetAvailableSchemasFromRegister()
 	if err != nil {
 		log.Print(err)
+		complete = false
 	}
 
 	sort.Sort(ByFilename(schemaList.Business))
 	sort.Sort(ByFilename(schemaList.Census))
 	sort.Sort(ByFilename(schemaList.Social))
 	sort.Sort(ByFilename(schemaList.Test))
-	sort.Sort(ByFilename(schemaList.Register))
+	sort.Sort(ByVersion(schemaList.Register))
 	sort.Sort(ByFilename(schemaList.Other))
 
-	return schemaList
+	return schemaList, complete
 }
 
 // ByFilename implements sort.Interface based on the Name field.
@@ -131,73 +232,149 @@ func (a ByFilename) Len() int           { return len(a) }
 func (a ByFilename) Less(i, j int) bool { return a[i].Name < a[j].Name }
 func (a ByFilename) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
-// GetAvailableSchemasFromRegister Gets published questionnaires from register
-func GetAvailableSchemasFromRegister(httpClient *http.Client) ([]LauncherSchema, error) {
+// ByVersion implements sort.Interface for register schemas, ordering each survey's versions newest first.
+type ByVersion []LauncherSchema
 
-	schemaList := []LauncherSchema{}
+func (a ByVersion) Len() int      { return len(a) }
+func (a ByVersion) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByVersion) Less(i, j int) bool {
+	if a[i].SurveyID != a[j].SurveyID {
+		return a[i].SurveyID < a[j].SurveyID
+	}
+	if a[i].FormType != a[j].FormType {
+		return a[i].FormType < a[j].FormType
+	}
+	vi, _ := strconv.Atoi(a[i].SurveyVersion)
+	vj, _ := strconv.Atoi(a[j].SurveyVersion)
+	return vi > vj
+}
 
-	registerURL := settings.Get("SURVEY_REGISTER_URL")
+// RegisterSchemaID is the stable ID of a published version of a register survey
+func RegisterSchemaID(surveyID, formType, surveyVersion string) string {
+	return fmt.Sprintf("register:%s:%s:%s", surveyID, formType, surveyVersion)
+}
 
-	url := fmt.Sprintf("%s/questionnaires/published", registerURL)
+func (s *Service) registerLauncherSchema(questionnaire RegisterResponse, title string) LauncherSchema {
+	registerURL := s.config.Get("SURVEY_REGISTER_URL")
 
-	if registerURL != "" {
+	name := fmt.Sprintf("%s_%s %s (v%s)", questionnaire.SurveyID, questionnaire.FormType, title, questionnaire.SurveyVersion)
+	published, err := time.Parse(time.RFC3339, questionnaire.LastPublished)
+	if err == nil {
+		name = fmt.Sprintf("%s_%s %s (v%s - %d/%d/%d)", questionnaire.SurveyID, questionnaire.FormType, title, questionnaire.SurveyVersion, published.Day(), published.Month(), published.Year())
+	}
 
-		resp, err := httpClient.Get(url)
-		if err != nil {
-			return nil, fmt.Errorf("WARN: Failed to contact %s; skipping schema repository", url)
-		}
+	return LauncherSchema{
+		ID:            RegisterSchemaID(questionnaire.SurveyID, questionnaire.FormType, questionnaire.SurveyVersion),
+		Name:          name,
+		URL:           fmt.Sprintf("%s/questionnaires/version?survey_id=%s&form_type=%s&survey_version=%s", registerURL, url.QueryEscape(questionnaire.SurveyID), url.QueryEscape(questionnaire.FormType), url.QueryEscape(questionnaire.SurveyVersion)),
+		EqID:          questionnaire.EqID,
+		FormType:      questionnaire.FormType,
+		SurveyID:      questionnaire.SurveyID,
+		SurveyVersion: questionnaire.SurveyVersion,
+		Title:         title,
+		Published:     published,
+		RegistryID:    questionnaire.RegistryID,
+		BodyParams: ReqVersionBodyParams{
+			SurveyID:      questionnaire.SurveyID,
+			FormType:      questionnaire.FormType,
+			SurveyVersion: questionnaire.SurveyVersion,
+		},
+	}
+}
 
-		responseBody, err := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return nil, fmt.Errorf("WARN: Failed to read response from %s; skipping schema repository", url)
-		}
+func (s *Service) getRegisterJSON(url string, v interface{}) (int, error) {
+	resp, err := s.httpClient.Get(url)
+	if err != nil {
+		return 0, fmt.Errorf("WARN: Failed to contact %s; skipping schema repository", url)
+	}
 
-		var questionnaires []RegisterResponse
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("WARN: Failed to read response from %s; skipping schema repository", url)
+	}
 
-		err = json.Unmarshal(responseBody, &questionnaires)
-		if err != nil {
-			return nil, fmt.Errorf("WARN: Failed to unmarshall response from %s; skipping schema repository", url)
-		}
+	if resp.StatusCode != 200 {
+		return resp.StatusCode, fmt.Errorf("WARN: Unexpected status code %d from %s; skipping schema repository", resp.StatusCode, url)
+	}
 
-		for _, questionnaire := range questionnaires {
+	if err := json.Unmarshal(responseBody, v); err != nil {
+		return resp.StatusCode, fmt.Errorf("WARN: Failed to unmarshall response from %s; skipping schema repository", url)
+	}
 
-			t, err := time.Parse(time.RFC3339, questionnaire.LastPublished)
-			if err != nil {
-				log.Printf("WARN: Failed to parse LastPublished date; skipping questionnaire: %s_%s", questionnaire.SurveyID, questionnaire.FormType)
-				continue
-			}
+	return resp.StatusCode, nil
+}
 
-			numOfVersions, err := strconv.Atoi(questionnaire.SurveyVersion)
-			if err != nil {
-				log.Printf("WARN: convert survey version string to int; skipping questionnaire: %s_%s", questionnaire.SurveyID, questionnaire.FormType)
-				continue
+// getRegisterVersions fetches every published version of a questionnaire. Registers without the versions
+// endpoint only describe the latest version, so only that version is listed.
+func (s *Service) getRegisterVersions(questionnaire RegisterResponse) ([]RegisterResponse, error) {
+	registerURL := s.config.Get("SURVEY_REGISTER_URL")
+	versionsURL := fmt.Sprintf("%s/questionnaires/versions?survey_id=%s&form_type=%s", registerURL, url.QueryEscape(questionnaire.SurveyID), url.QueryEscape(questionnaire.FormType))
+
+	var versions []RegisterResponse
+	statusCode, err := s.getRegisterJSON(versionsURL, &versions)
+	if err == nil {
+		for i := range versions {
+			if versions[i].SurveyID == "" {
+				versions[i].SurveyID = questionnaire.SurveyID
+			}
+			if versions[i].FormType == "" {
+				versions[i].FormType = questionnaire.FormType
+			}
+			if versions[i].EqID == "" {
+				versions[i].EqID = questionnaire.EqID
 			}
+		}
+		return versions, nil
+	}
+	if statusCode != http.StatusNotFound {
+		return nil, err
+	}
 
-			for i := 1; i <= numOfVersions; i++ {
+	return []RegisterResponse{questionnaire}, nil
+}
 
-				schemaList = append(schemaList, LauncherSchema{
-					Name:     fmt.Sprintf("%s_%s %s (v%d - %d/%d/%d)", questionnaire.SurveyID, questionnaire.FormType, questionnaire.Title, i, t.Day(), t.Month(), t.Year()),
-					URL:      fmt.Sprintf("%s/questionnaires/version?survey_id=%s&form_type=%s&survey_version=%d", registerURL, questionnaire.SurveyID, questionnaire.FormType, i),
-					EqID:     questionnaire.EqID,
-					FormType: questionnaire.FormType,
-				})
-			}
+// GetAvailableSchemasFromRegister Gets every published version of the questionnaires in the register
+func (s *Service) GetAvailableSchemasFromRegister() ([]LauncherSchema, error) {
+
+	schemaList := []LauncherSchema{}
+
+	registerURL := s.config.Get("SURVEY_REGISTER_URL")
+
+	if registerURL == "" {
+		return schemaList, nil
+	}
+
+	var questionnaires []RegisterResponse
+	if _, err := s.getRegisterJSON(fmt.Sprintf("%s/questionnaires/published", registerURL), &questionnaires); err != nil {
+		return nil, err
+	}
+
+	for _, questionnaire := range questionnaires {
+		versions, err := s.getRegisterVersions(questionnaire)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+
+		for _, version := range versions {
+			// Every version is listed under the title of the latest
+			schemaList = append(schemaList, s.registerLauncherSchema(version, questionnaire.Title))
 		}
 	}
 
 	return schemaList, nil
 }
 
-func getAvailableSchemasFromRunner(httpClient *http.Client) ([]LauncherSchema, error) {
+func (s *Service) getAvailableSchemasFromRunner() ([]LauncherSchema, error) {
 
 	schemaList := []LauncherSchema{}
 
-	hostURL := settings.Get("SURVEY_RUNNER_SCHEMA_URL")
+	hostURL := s.config.Get("SURVEY_RUNNER_SCHEMA_URL")
 
 	url := fmt.Sprintf("%s/schemas", hostURL)
 
-	resp, err := httpClient.Get(url)
+	resp, err := s.httpClient.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to contact EQRunner at %s", url)
 	}
@@ -226,39 +403,12 @@ func getAvailableSchemasFromRunner(httpClient *http.Client) ([]LauncherSchema, e
 	return schemaList, nil
 }
 
-// FindSurveyByName Finds the schema in the list of available schemas
-func FindSurveyByName(name string) LauncherSchema {
-	availableSchemas := GetAvailableSchemas()
-
-	for _, survey := range availableSchemas.Business {
-		if survey.Name == name {
-			return survey
-		}
-	}
-	for _, survey := range availableSchemas.Census {
-		if survey.Name == name {
-			return survey
-		}
-	}
-	for _, survey := range availableSchemas.Social {
-		if survey.Name == name {
-			return survey
-		}
-	}
-	for _, survey := range availableSchemas.Test {
-		if survey.Name == name {
-			return survey
-		}
-	}
-	for _, survey := range availableSchemas.Register {
-		if survey.Name == name {
-			return survey
-		}
-	}
-	for _, survey := range availableSchemas.Other {
-		if survey.Name == name {
-			return survey
+// FindSurvey Finds the schema with the given ID in the list of available schemas
+func (s *Service) FindSurvey(id string) (LauncherSchema, error) {
+	for _, survey := range s.GetAvailableSchemas().All() {
+		if survey.ID == id {
+			return survey, nil
 		}
 	}
-	panic("Survey not found")
+	return LauncherSchema{}, &NotFoundError{ID: id}
 }
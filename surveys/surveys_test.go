@@ -2,10 +2,13 @@ package surveys
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/ONSdigital/go-launch-a-survey/settings"
 )
@@ -30,11 +33,12 @@ func TestIfLauncherCanMakeCallToRegisterAPI(t *testing.T) {
 		}
 	})
 
-	registerURL := settings.Get("SURVEY_REGISTER_URL")
+	config := settings.FromValues(nil)
+	registerURL := config.Get("SURVEY_REGISTER_URL")
 	url := fmt.Sprintf("%s/published-questionnaires", registerURL)
 	potentialErr := fmt.Errorf("WARN: Failed to contact %s; skipping schema repository", url)
 
-	_, err := GetAvailableSchemasFromRegister(client)
+	_, err := NewService(config, client).GetAvailableSchemasFromRegister()
 	if err == potentialErr {
 		t.Errorf("Failed to contact %s", url)
 	}
@@ -43,11 +47,12 @@ func TestIfLauncherCanMakeCallToRegisterAPI(t *testing.T) {
 	}
 }
 
-func TestIfLauncherCanBuildLaunchSchemaFromRegisterResponse(t *testing.T) {
-	client := NewTestClient(func(req *http.Request) *http.Response {
-		return &http.Response{
-			StatusCode: 200,
-			Body: ioutil.NopCloser(bytes.NewBufferString(`
+func newRegisterTestClient(versionsStatus int, versionsBody string) *http.Client {
+	return NewTestClient(func(req *http.Request) *http.Response {
+		status, body := 200, `[]`
+		switch req.URL.Path {
+		case "/questionnaires/published":
+			body = `
 			[
 				{
 					"registry_id": "b02f1331-57f3-4427-8182-c969dbed6414",
@@ -55,34 +60,143 @@ func TestIfLauncherCanBuildLaunchSchemaFromRegisterResponse(t *testing.T) {
 					"form_type": "002",
 					"title": "Ecommerce",
 					"lastPublished": "2019-12-12T08:55:27.731Z",
-					"survey_version": "1",
+					"survey_version": "2",
 					"eq_id": "123-456-789"
 				}
-			]`)),
-			Header: make(http.Header),
+			]`
+		case "/questionnaires/versions":
+			status, body = versionsStatus, versionsBody
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
 		}
 	})
+}
 
-	registerURL := settings.Get("SURVEY_REGISTER_URL")
+func TestIfLauncherCanBuildLaunchSchemaFromRegisterResponse(t *testing.T) {
+	client := newRegisterTestClient(200, `
+	[
+		{"registry_id": "a1", "survey_version": "1", "lastPublished": "2019-11-01T10:00:00.000Z"},
+		{"registry_id": "b02f1331-57f3-4427-8182-c969dbed6414", "survey_version": "2", "lastPublished": "2019-12-12T08:55:27.731Z"}
+	]`)
 
-	var expectedLauncherSchemas []LauncherSchema
+	config := settings.FromValues(nil)
+	registerURL := config.Get("SURVEY_REGISTER_URL")
 
-	expectedLauncherSchemas = append(expectedLauncherSchemas, LauncherSchema{
-		Name:     "187_002 Ecommerce (v1 - 12/12/2019)",
-		URL:      fmt.Sprintf(`%s/questionnaire/187/002/1`, registerURL),
-		EqID:     "123-456-789",
-		FormType: "002",
-	})
+	launcherSchemas, err := NewService(config, client).GetAvailableSchemasFromRegister()
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if len(launcherSchemas) != 2 {
+		t.Fatalf("Expected a launcherSchema per version but recieved %v", launcherSchemas)
+	}
 
-	launcherSchema, err := GetAvailableSchemasFromRegister(client)
+	expected := LauncherSchema{
+		ID:            "register:187:002:2",
+		Name:          "187_002 Ecommerce (v2 - 12/12/2019)",
+		URL:           fmt.Sprintf(`%s/questionnaires/version?survey_id=187&form_type=002&survey_version=2`, registerURL),
+		EqID:          "123-456-789",
+		FormType:      "002",
+		SurveyID:      "187",
+		SurveyVersion: "2",
+		Title:         "Ecommerce",
+		Published:     time.Date(2019, 12, 12, 8, 55, 27, 731000000, time.UTC),
+		RegistryID:    "b02f1331-57f3-4427-8182-c969dbed6414",
+		BodyParams:    ReqVersionBodyParams{SurveyID: "187", FormType: "002", SurveyVersion: "2"},
+	}
+	if launcherSchemas[1] != expected {
+		t.Errorf("Built launcherSchema incorrectly; expected %v but recieved %v", expected, launcherSchemas[1])
+	}
+	if launcherSchemas[0].RegistryID != "a1" || launcherSchemas[0].SurveyVersion != "1" || launcherSchemas[0].EqID != "123-456-789" {
+		t.Errorf("Built launcherSchema for version 1 incorrectly; recieved %v", launcherSchemas[0])
+	}
+}
 
+func TestIfLauncherListsLatestVersionForRegistersWithoutVersionsEndpoint(t *testing.T) {
+	client := newRegisterTestClient(404, `{}`)
+
+	launcherSchemas, err := NewService(settings.FromValues(nil), client).GetAvailableSchemasFromRegister()
 	if err != nil {
-		t.Errorf("Error %s recieved, expected nil", err)
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	// Earlier versions may not exist, so only the version the register reported is listed
+	if len(launcherSchemas) != 1 {
+		t.Fatalf("Expected only the latest version but recieved %v", launcherSchemas)
 	}
-	if launcherSchema[0] != expectedLauncherSchemas[0] {
-		t.Errorf("Built launcherSchema incorrectly; expected %s but recieved %s", expectedLauncherSchemas, launcherSchema)
+	if launcherSchemas[0].SurveyVersion != "2" || launcherSchemas[0].RegistryID == "" {
+		t.Errorf("Expected the latest version with its register details but recieved %v", launcherSchemas[0])
 	}
+}
+
+func TestFindSurveyUsesStableIDs(t *testing.T) {
+	service := NewService(settings.FromValues(nil), newRegisterTestClient(200, `[{"survey_version": "1"}, {"survey_version": "2"}]`))
 
+	launcherSchema, err := service.FindSurvey("register:187:002:1")
+	if err != nil {
+		t.Fatalf("Error %s recieved, expected nil", err)
+	}
+	if launcherSchema.SurveyVersion != "1" {
+		t.Errorf("Expected version 1 but recieved %v", launcherSchema)
+	}
+
+	_, err = service.FindSurvey("187_002 Ecommerce (v1)")
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) {
+		t.Errorf("Expected a NotFoundError for a display name but recieved %v", err)
+	}
+}
+
+func TestAvailableSchemasAreCached(t *testing.T) {
+	requests := 0
+	failing := true
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		requests++
+		status := http.StatusOK
+		if failing {
+			status = http.StatusServiceUnavailable
+		}
+		return &http.Response{StatusCode: status, Body: ioutil.NopCloser(bytes.NewBufferString(`[]`)), Header: make(http.Header)}
+	})
+	service := NewService(settings.FromValues(nil), client)
+
+	// A list missing a repository isn't kept
+	service.GetAvailableSchemas()
+	failing = false
+	service.GetAvailableSchemas()
+	afterFailure := requests
+
+	service.GetAvailableSchemas()
+	service.FindSurvey("census_household.json")
+	if requests != afterFailure {
+		t.Errorf("Expected the schema list to be fetched once but it was fetched %d more times", requests-afterFailure)
+	}
+
+	uncached := NewService(settings.FromValues(map[string]string{"SCHEMA_LIST_LIFETIME": "0s"}), client)
+	uncached.GetAvailableSchemas()
+	before := requests
+	uncached.GetAvailableSchemas()
+	if requests == before {
+		t.Errorf("Expected the schema list not to be cached with SCHEMA_LIST_LIFETIME 0s")
+	}
+}
+
+func TestRegisterSurveysGroupsVersionsNewestFirst(t *testing.T) {
+	schemas := LauncherSchemas{Register: []LauncherSchema{
+		{ID: "register:187:002:10", SurveyID: "187", FormType: "002", SurveyVersion: "10"},
+		{ID: "register:187:002:9", SurveyID: "187", FormType: "002", SurveyVersion: "9"},
+		{ID: "register:139:0001:1", SurveyID: "139", FormType: "0001", SurveyVersion: "1"},
+	}}
+	sort.Sort(ByVersion(schemas.Register))
+
+	registerSurveys := schemas.RegisterSurveys()
+	if len(registerSurveys) != 2 || registerSurveys[0].Key != "139_0001" {
+		t.Fatalf("Expected two surveys ordered by survey_id but recieved %v", registerSurveys)
+	}
+	if registerSurveys[1].Versions[0].SurveyVersion != "10" || registerSurveys[1].Versions[1].SurveyVersion != "9" {
+		t.Errorf("Expected versions newest first but recieved %v", registerSurveys[1].Versions)
+	}
 }
 
 func TestIfLauncherCanMakeCallToEqRunnerAPI(t *testing.T) {
@@ -98,9 +212,10 @@ func TestIfLauncherCanMakeCallToEqRunnerAPI(t *testing.T) {
 		}
 	})
 
-	_, err := getAvailableSchemasFromRunner(client)
+	config := settings.FromValues(nil)
+	_, err := NewService(config, client).getAvailableSchemasFromRunner()
 
-	surveyRunnerURL := settings.Get("SURVEY_RUNNER_URL")
+	surveyRunnerURL := config.Get("SURVEY_RUNNER_URL")
 	url := fmt.Sprintf("%s/published-questionnaires", surveyRunnerURL)
 	potentialErr := fmt.Errorf("WARN: Failed to contact %s; skipping schema repository", url)
 	if err == potentialErr {
@@ -111,6 +226,31 @@ func TestIfLauncherCanMakeCallToEqRunnerAPI(t *testing.T) {
 	}
 }
 
+func TestServicesUseTheirOwnSettings(t *testing.T) {
+	var requestedURLs []string
+
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		requestedURLs = append(requestedURLs, req.URL.String())
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`[]`)),
+			Header:     make(http.Header),
+		}
+	})
+
+	for _, registerURL := range []string{"http://register-one:8080", "http://register-two:8080"} {
+		config := settings.FromValues(map[string]string{"SURVEY_REGISTER_URL": registerURL})
+		if _, err := NewService(config, client).GetAvailableSchemasFromRegister(); err != nil {
+			t.Errorf("Error %s recieved, expected nil", err)
+		}
+	}
+
+	expectedURLs := []string{"http://register-one:8080/questionnaires/published", "http://register-two:8080/questionnaires/published"}
+	if fmt.Sprint(requestedURLs) != fmt.Sprint(expectedURLs) {
+		t.Errorf("Expected requests to %v but recieved %v", expectedURLs, requestedURLs)
+	}
+}
+
 // RoundTripFunc .
 type RoundTripFunc func(req *http.Request) *http.Response
 